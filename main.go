@@ -2,211 +2,831 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
-	"net/netip"
+	"maps"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/cloudflare/cloudflare-go"
-	"tailscale.com/client/tailscale"
+	"github.com/sclem/cloudflare-tailscale-dns/tsdns"
 )
 
-type DNSDomain struct {
-	Domain string
-	Sub    string
-	Tag    string
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return "flags"
 }
 
-func (d DNSDomain) BuildHostname(host string) string {
-	return strings.ToLower(host) + "." + d.String()
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// timeWriter prefixes every log line with the current time in layout.
+type timeWriter struct {
+	w      io.Writer
+	layout string
 }
 
-func (d DNSDomain) String() string {
-	suffix := d.Domain
-	if len(d.Sub) > 0 {
-		suffix = d.Sub + "." + d.Domain
+func (t timeWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(t.w, time.Now().Format(t.layout)+" "); err != nil {
+		return 0, err
 	}
-	return strings.ToLower(suffix)
+	return t.w.Write(p)
+}
+
+// dedupWriter drops log lines repeating one written less than window ago and
+// reports how often a line was repeated once its window is over.
+type dedupWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	window time.Duration
+	seen   map[string]*dedupLine
 }
 
-type tailHost struct {
-	Name string
-	IP   netip.Addr
+type dedupLine struct {
+	first    time.Time
+	repeated int
 }
 
-func (t tailHost) RecordType() string {
-	if t.IP.Is6() {
-		return "AAAA"
+func (d *dedupWriter) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for line, l := range d.seen {
+		if now.Sub(l.first) < d.window {
+			continue
+		}
+		delete(d.seen, line)
+		if l.repeated > 0 {
+			if _, err := fmt.Fprintf(d.w, "repeated %d more times: %s", l.repeated, line); err != nil {
+				return 0, err
+			}
+		}
+	}
+	line := string(p)
+	if l, ok := d.seen[line]; ok {
+		l.repeated++
+		return len(p), nil
 	}
-	return "A"
+	d.seen[line] = &dedupLine{first: now}
+	return d.w.Write(p)
 }
 
-type arrayFlags []string
+// cmdline and fromEnv hold the names of the flags given on the command line
+// and the ones set from the environment.
+var cmdline, fromEnv = make(map[string]bool), make(map[string]bool)
 
-func (i *arrayFlags) String() string {
-	return "flags"
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	return cmdline[name]
 }
 
-func (i *arrayFlags) Set(value string) error {
-	*i = append(*i, value)
-	return nil
+// modeFlags select what the program does. A command replaces them.
+var modeFlags = []string{"remove-all", "list", "probe", "export", "watch", "delete-id", "explain"}
+
+// envPrefix prefixes the environment variables that set flags, e.g.
+// CTS_ZONE for -zone.
+const envPrefix = "CTS_"
+
+// envFlags are the flags that can be set from the environment: the ones
+// configuring a sync. Modes and destructive or one-shot flags such as
+// -remove-all, -delete-id or -yes are only taken from the command line.
+var envFlags = []string{
+	"config", "zone", "subdomain", "subdomain-zone", "tag", "tag-template", "name-template",
+	"endpoint-suffix", "map-ip-to-name", "sanitize-replacement", "magicdns", "magicdns-suffix",
+	"tag-as-subdomain", "tag-subdomain-each", "flatten", "separator", "zone-suffix-match-strict",
+	"ttl", "ttl-a", "ttl-aaaa", "ttl-cname", "ipv6-format", "proxied", "proxied-tag",
+	"instance-id", "orphan-after", "online-threshold", "offline-threshold", "peer-state",
+	"max-creates", "first-run-protect", "alias", "hosts-txt", "catchall", "override-file",
+	"split-horizon", "external-override", "override", "allow-non-tailnet-ip", "exclude-expired",
+	"warn-expiring", "skip-self", "require-self-tag", "self-respects-tag", "always-include",
+	"os", "exit-nodes-only", "capability", "group", "attr", "peer-limit", "output-state",
+	"report-out", "audit-log", "since-bootstrap", "state-max-age", "metrics-addr",
+	"error-interval", "interval-jitter", "tailscale-source", "tailnet", "tailscale-api-url",
+	"cf-base-url", "cf-rate-limit", "batch", "concurrency", "zone-concurrency", "cf-per-page",
+	"v", "log-timestamps", "log-dedup", "log-time-format",
 }
 
-func sanitizeHost(s string) string {
-	return strings.Replace(s, " ", "-", -1)
+// setFromEnv sets every flag of envFlags not given on the command line from
+// its environment variable. Repeatable flags take a list separated by ";",
+// since values such as -alias host=a,b contain commas. Variables of other
+// flags are ignored with a warning.
+func setFromEnv() {
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		v, ok := os.LookupEnv(name)
+		if !ok || flagSet(f.Name) {
+			return
+		}
+		if !slices.Contains(envFlags, f.Name) {
+			log.Printf("warning: ignoring %s, -%s can only be given on the command line", name, f.Name)
+			return
+		}
+		fromEnv[f.Name] = true
+		values := []string{v}
+		if _, repeatable := f.Value.(*arrayFlags); repeatable {
+			values = slices.DeleteFunc(strings.Split(v, ";"), func(v string) bool {
+				return len(strings.TrimSpace(v)) == 0
+			})
+		}
+		for _, v := range values {
+			if err := flag.Set(f.Name, v); err != nil {
+				log.Fatalf("invalid %s: %v", name, err)
+			}
+		}
+	})
 }
 
 func main() {
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
-	dd := DNSDomain{}
-	var removeAll, removeUnused bool
-	var alias arrayFlags
-	flag.StringVar(&dd.Domain, "zone", "", "zone, ex. example.com")
-	flag.StringVar(&dd.Sub, "subdomain", "", "subdomain to use, e.g. 'wg' will make dns records as <tailscale host>.wg.example.com")
+	dd := tsdns.DNSDomain{}
+	var removeAll, removeUnused, pruneOnly, sinceBootstrap, list, proxied, skipSelf, selfRespectsTag, probeOnly, yes, magicDNS, verbose, tagSubdomainEach, dryRun, logTimestamps, allowNonTailnetIP, replace, excludeExpired, selfTest, batch, explain, exitNodesOnly, clobberForeign bool
+	var perPage, protectThreshold, maxCreates, onlineThreshold, offlineThreshold, ttl, peerLimit, concurrency, zoneConcurrency int
+	var ttlA, ttlAAAA, ttlCNAME int
+	var rateLimit float64
+	var stateFile, reportFile, auditLog, nameTemplate, migrateFrom, cfBaseURL string
+	var tsSource, tailnet, tsAPIURL, ipv6Format, adoptPattern, magicDNSSuffix, instanceID, logTimeFormat, exportFile, exportFormat, sanitizeReplacement, statusFile, recordsFile, endpointSuffix, mapIPToName, hostsTXT, peerStateFile, group, proxiedTag, overrideFile, metricsAddr, requireSelfTag, catchall, capability string
+	var watch, watchJitter, errorInterval, warnExpiring, orphanAfter, stateMaxAge, logDedup time.Duration
+	var alias, osFilter, attrFilter, subdomains, subdomainZones, override, deleteIDs, configFiles, tagSubdomains, tagTemplates, alwaysInclude, splitHorizon, externalOverride arrayFlags
+	flag.Var(&configFiles, "config", "json config file with per host settings, later files override earlier ones (can be specified multiple times)")
+	flag.StringVar(&dd.Domain, "zone", "", "zone, ex. example.com (default the only zone the token can access)")
+	flag.Var(&subdomains, "subdomain", "subdomain to use, e.g. 'wg' will make dns records as <tailscale host>.wg.example.com (can be specified multiple times)")
+	flag.Var(&subdomainZones, "subdomain-zone", "cloudflare zone for a delegated subdomain, e.g. wg=wg.example.com (can be specified multiple times)")
 	flag.StringVar(&dd.Tag, "tag", "", "only add records for hosts with this tag")
+	flag.StringVar(&migrateFrom, "migrate-from-template", "", "previous -name-template; managed records under the old names are renamed instead of recreated")
+	flag.Var(&tagTemplates, "tag-template", "name template for hosts with a tag, tag=template, e.g. 'tag:db={{.Host}}.db' (can be specified multiple times)")
+	flag.StringVar(&nameTemplate, "name-template", "{{.Host}}", "go template for the host part of record names, see README for available functions")
+	flag.StringVar(&endpointSuffix, "endpoint-suffix", "", "also add a record named host<suffix> for the address tailscaled reaches each peer at directly, e.g. -wan")
+	flag.StringVar(&mapIPToName, "map-ip-to-name", "", "name records after their tailscale ip with this prefix instead of the host, e.g. host- makes host-100-64-0-5")
+	flag.StringVar(&sanitizeReplacement, "sanitize-replacement", "-", "replacement for characters not allowed in record names, such as spaces; empty removes them")
+	flag.BoolVar(&magicDNS, "magicdns", false, "name records after the MagicDNS host label instead of the tailscale hostname")
+	flag.StringVar(&magicDNSSuffix, "magicdns-suffix", "", "tailnet suffix to strip from MagicDNS names, e.g. tailnet-name.ts.net (detected from tailscaled when empty)")
+	flag.Var(&tagSubdomains, "tag-as-subdomain", "insert this tag's name as a label after the host name of peers carrying it, e.g. tag:prod makes <host>.prod.<subdomain>.<zone> (can be specified multiple times)")
+	flag.BoolVar(&tagSubdomainEach, "tag-subdomain-each", false, "create one record per matching -tag-as-subdomain tag instead of failing when a host has several")
+	flag.BoolVar(&dd.Flatten, "flatten", false, "build records as a single label under the zone, e.g. <tailscale host>-wg.example.com")
+	flag.StringVar(&dd.Separator, "separator", "-", "separator between host and subdomain when using -flatten")
+	flag.BoolVar(&dd.Strict, "zone-suffix-match-strict", true, "only treat records as managed when the subdomain suffix starts at a label boundary")
+	flag.IntVar(&ttl, "ttl", tsdns.DefaultTTL, "record ttl in seconds for unproxied records, 1 for automatic")
+	flag.IntVar(&ttlA, "ttl-a", 0, "ttl for unproxied A records, overrides -ttl (0 uses -ttl)")
+	flag.IntVar(&ttlAAAA, "ttl-aaaa", 0, "ttl for unproxied AAAA records, overrides -ttl (0 uses -ttl)")
+	flag.IntVar(&ttlCNAME, "ttl-cname", 0, "ttl for unproxied CNAME records, overrides -ttl (0 uses -ttl)")
+	flag.StringVar(&ipv6Format, "ipv6-format", "compressed", "AAAA record content format: compressed or expanded")
+	flag.BoolVar(&proxied, "proxied", false, "proxy records through cloudflare")
+	flag.StringVar(&proxiedTag, "proxied-tag", "", "proxy the records of peers with this tag through cloudflare, e.g. tag:dns-proxied")
+	flag.BoolVar(&replace, "replace", false, "delete and recreate changed records instead of updating them, and remove managed records of a host whose type changed")
 	flag.BoolVar(&removeUnused, "remove-orphans", false, "remove DNS records that are not in tailscale")
-	flag.BoolVar(&removeAll, "remove-all", false, "remove all tailscale dns records")
+	flag.StringVar(&instanceID, "instance-id", "", "id recorded in the owner marker; orphan removal only touches records with the same id")
+	flag.DurationVar(&orphanAfter, "orphan-after", 0, "only remove the records of a host once it has not been seen for this long, e.g. 24h")
+	flag.BoolVar(&clobberForeign, "clobber-foreign", false, "overwrite records without the owner marker that a host's record would replace")
+	flag.IntVar(&onlineThreshold, "online-threshold", 1, "consecutive syncs a peer must be online before it gets records")
+	flag.IntVar(&offlineThreshold, "offline-threshold", 1, "consecutive syncs a peer must be offline before it loses its records")
+	flag.StringVar(&peerStateFile, "peer-state", "", "file keeping the -online-threshold and -offline-threshold counts between runs")
+	flag.StringVar(&adoptPattern, "adopt", "", "take over unmanaged records under the subdomain whose name or comment matches this regexp")
+	flag.IntVar(&maxCreates, "max-creates", 0, "abort without changes when a zone would get more than this many new records (0 is unlimited)")
+	flag.IntVar(&protectThreshold, "first-run-protect", 0, "refuse to remove orphans when fewer than this many managed records currently exist (0 disables)")
+	flag.BoolVar(&removeAll, "remove-all", false, "remove every dns record this instance manages")
+	flag.Var(&deleteIDs, "delete-id", "delete the cloudflare record with this id and exit, requires -yes (can be specified multiple times)")
+	flag.BoolVar(&yes, "yes", false, "confirm -delete-id")
 	flag.Var(&alias, "alias", "alias records")
+	flag.StringVar(&hostsTXT, "hosts-txt", "", "also maintain a TXT record with this label listing every host, e.g. _hosts")
+	flag.StringVar(&catchall, "catchall", "", "point the wildcard record *.<subdomain>.<zone> at this host")
+	flag.StringVar(&overrideFile, "override-file", "", "file with one host=[a|aaaa|cname:]content override per line")
+	flag.Var(&splitHorizon, "split-horizon", "publish this -subdomain as the external view: only hosts with an -external-override, with that content (can be specified multiple times)")
+	flag.Var(&externalOverride, "external-override", "content of a host under the -split-horizon subdomains, host=[a|aaaa|cname:]content (can be specified multiple times)")
+	flag.Var(&override, "override", "point a host at fixed content instead of its tailscale ip, host=[a|aaaa|cname:]content (can be specified multiple times)")
+	flag.BoolVar(&allowNonTailnetIP, "allow-non-tailnet-ip", false, "publish addresses outside 100.64.0.0/10 and fd7a:115c:a1e0::/48 instead of skipping them with a warning")
+	flag.BoolVar(&excludeExpired, "exclude-expired", false, "do not add records for nodes whose key has expired")
+	flag.DurationVar(&warnExpiring, "warn-expiring", 0, "warn about nodes whose key expires within this duration, e.g. 72h")
+	flag.BoolVar(&skipSelf, "skip-self", false, "do not add records for the local node")
+	flag.StringVar(&requireSelfTag, "require-self-tag", "", "refuse to sync unless the local node carries this tag, e.g. tag:dns-sync")
+	flag.BoolVar(&selfRespectsTag, "self-respects-tag", false, "only add records for the local node if it matches -tag and -os like any peer")
+	flag.Var(&alwaysInclude, "always-include", "add records for the peer with this hostname regardless of -tag and the other filters (can be specified multiple times)")
+	flag.Var(&osFilter, "os", "only add records for peers running this os, e.g. linux (can be specified multiple times)")
+	flag.BoolVar(&exitNodesOnly, "exit-nodes-only", false, "only add records for peers offering an approved exit node, requires the local tailscale source")
+	flag.StringVar(&capability, "capability", "", "only add records for peers granted this node capability, requires the local tailscale source")
+	flag.StringVar(&group, "group", "", "only add records for devices owned by a member of this policy file group, e.g. group:eng, requires -tailscale-source api")
+	flag.Var(&attrFilter, "attr", "only add records for peers with this posture attribute, key=value, requires -tailscale-source api (can be specified multiple times)")
+	flag.IntVar(&peerLimit, "peer-limit", 0, "only process the first N hosts sorted by name (0 for no limit)")
+	flag.BoolVar(&probeOnly, "probe", false, "check that tailscale and cloudflare are reachable and the zones exist, then exit")
+	flag.BoolVar(&list, "list", false, "print the current dns records under the subdomain and exit")
+	flag.StringVar(&exportFile, "export", "", "write the managed records to this file, '-' for stdout, and exit")
+	flag.StringVar(&exportFormat, "format", "json", "-export format: json, bind (zone file) or terraform (import commands)")
+	flag.StringVar(&stateFile, "output-state", "", "write the managed records to this file as json after a successful sync")
+	flag.StringVar(&reportFile, "report-out", "", "write a json report of the actions taken by each sync to this file")
+	flag.StringVar(&auditLog, "audit-log", "", "append a json line for every dns record change made to this file")
+	flag.BoolVar(&sinceBootstrap, "since-bootstrap", false, "never remove orphans until a previous run has written the -output-state file")
+	flag.DurationVar(&stateMaxAge, "state-max-age", 0, "ignore -output-state and -peer-state files last written longer ago than this and remove no orphans, e.g. 168h (0 never expires)")
+	flag.DurationVar(&watch, "watch", 0, "keep running and sync every interval, e.g. 5m")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve openmetrics on this address at /metrics, e.g. :9100")
+	flag.DurationVar(&errorInterval, "error-interval", 0, "with -watch, retry a failed sync after this long, doubling up to the -watch interval, e.g. 15s")
+	flag.DurationVar(&watchJitter, "interval-jitter", 0, "with -watch, wait up to this much longer, at random, between syncs, e.g. 30s")
+	flag.StringVar(&tsSource, "tailscale-source", "local", "where to read tailscale devices from: local (tailscaled), api or file")
+	flag.StringVar(&statusFile, "status-file", "", "read tailscale devices from a recorded tailscale status --json, implies -tailscale-source file")
+	flag.StringVar(&recordsFile, "records-file", "", "plan against a recorded cloudflare record list instead of the zone, implies -dry-run")
+	flag.StringVar(&tailnet, "tailnet", "-", "tailnet to list devices from with -tailscale-source api, '-' for the credentials' default tailnet")
+	flag.StringVar(&tsAPIURL, "tailscale-api-url", tsdns.DefaultTailscaleAPI, "tailscale api base url")
+	flag.StringVar(&cfBaseURL, "cf-base-url", "", "override the cloudflare api base url, e.g. for a mock server")
+	flag.Float64Var(&rateLimit, "cf-rate-limit", 4, "maximum cloudflare api requests per second, shared by all zones")
+	flag.BoolVar(&batch, "batch", false, "send record changes in cloudflare batch requests, falling back to one request per change")
+	flag.IntVar(&concurrency, "concurrency", 1, "maximum concurrent record changes per zone")
+	flag.IntVar(&zoneConcurrency, "zone-concurrency", 1, "maximum zones synced concurrently")
+	flag.IntVar(&perPage, "cf-per-page", 100, "number of records to fetch per cloudflare list request (max 5000)")
+	flag.BoolVar(&explain, "explain", false, "print whether each tailscale device gets records or why it is skipped, and exit")
+	flag.BoolVar(&selfTest, "self-test", false, "print how sample hostnames, or the hostnames given as arguments, become record names and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "log the changes a sync would make and check the planned records, without changing anything")
+	flag.BoolVar(&verbose, "v", false, "log every record, including unchanged ones")
+	flag.BoolVar(&logTimestamps, "log-timestamps", true, "prefix log lines with a timestamp, disable when the log system adds its own")
+	flag.DurationVar(&logDedup, "log-dedup", 0, "log a line repeated within this window once, followed by a count of the repeats, e.g. 10m")
+	flag.StringVar(&logTimeFormat, "log-time-format", "", "go time layout for log timestamps, e.g. 2006-01-02T15:04:05Z07:00 (default the standard log format)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [sync|list|prune|probe|export [command flags]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	setFromEnv()
 
-	aliasMap := make(map[string][]string, 0)
-	for _, a := range alias {
-		parts := strings.SplitN(a, "=", 2)
-		if len(parts) == 2 {
-			host := parts[0]
-			aliases := strings.Split(parts[1], ",")
-			if len(aliases) > 0 {
-				aliasMap[host] = aliases
+	// A command selects the mode instead of the mode flags, e.g.
+	// "sync -dry-run" or "export -format bind records.zone". The flags
+	// before it are shared by every command.
+	if args := flag.Args(); len(args) > 0 && !selfTest {
+		for _, name := range modeFlags {
+			if flagSet(name) {
+				log.Fatalf("-%s cannot be combined with the %s command", name, args[0])
 			}
 		}
+		cmd := flag.NewFlagSet(args[0], flag.ExitOnError)
+		maxArgs := 0
+		pruneAll := false
+		switch args[0] {
+		case "sync":
+			cmd.BoolVar(&dryRun, "dry-run", dryRun, "log the changes the sync would make without changing anything")
+			cmd.BoolVar(&removeUnused, "remove-orphans", removeUnused, "remove DNS records that are not in tailscale")
+			cmd.DurationVar(&watch, "watch", watch, "keep running and sync every interval, e.g. 5m")
+			cmd.BoolVar(&verbose, "v", verbose, "log every record, including unchanged ones")
+		case "list":
+			cmd.IntVar(&perPage, "cf-per-page", perPage, "number of records to fetch per cloudflare list request (max 5000)")
+			list = true
+		case "prune":
+			cmd.BoolVar(&pruneAll, "all", false, "remove every managed record instead of only the orphans, like -remove-all")
+			cmd.BoolVar(&dryRun, "dry-run", dryRun, "log the changes the prune would make without changing anything")
+			cmd.DurationVar(&orphanAfter, "orphan-after", orphanAfter, "only remove the records of a host once it has not been seen for this long, e.g. 24h")
+			cmd.IntVar(&protectThreshold, "first-run-protect", protectThreshold, "refuse to remove orphans when fewer than this many managed records currently exist (0 disables)")
+			cmd.BoolVar(&verbose, "v", verbose, "log every record, including unchanged ones")
+		case "probe":
+			cmd.StringVar(&tsSource, "tailscale-source", tsSource, "where to read tailscale devices from: local (tailscaled), api or file")
+			probeOnly = true
+		case "export":
+			cmd.StringVar(&exportFormat, "format", exportFormat, "export format: json, bind (zone file) or terraform (import commands)")
+			exportFile = "-"
+			maxArgs = 1
+		default:
+			log.Fatalf("unknown command %q, expected sync, list, prune, probe or export", args[0])
+		}
+		cmd.Usage = func() {
+			fmt.Fprintf(cmd.Output(), "Usage: %s [flags] %s [command flags]\n", os.Args[0], args[0])
+			cmd.PrintDefaults()
+		}
+		cmd.Parse(args[1:])
+		if cmd.NArg() > maxArgs {
+			log.Fatalf("unexpected arguments for %s: %s", args[0], strings.Join(cmd.Args(), " "))
+		}
+		if cmd.NArg() == 1 {
+			exportFile = cmd.Arg(0)
+		}
+		// prune only removes orphans, prune -all removes every managed
+		// record.
+		if args[0] == "prune" {
+			removeAll = pruneAll
+			removeUnused = !pruneAll
+			pruneOnly = !pruneAll
+		}
 	}
 
-	ctx := context.Background()
-	status, err := tailscale.Status(ctx)
-	if err != nil {
-		log.Fatal(err)
+	var logOut io.Writer = os.Stderr
+	switch {
+	case !logTimestamps:
+		log.SetFlags(log.Lshortfile)
+	case len(logTimeFormat) > 0:
+		log.SetFlags(log.Lshortfile)
+		logOut = timeWriter{w: logOut, layout: logTimeFormat}
+	case logDedup > 0:
+		// Timestamps are added after deduplication, so repeated lines
+		// compare equal.
+		log.SetFlags(log.Lshortfile)
+		logOut = timeWriter{w: logOut, layout: "2006/01/02 15:04:05"}
 	}
-	hostList := make([]tailHost, 0, 1+len(status.Peer))
-	for _, ip := range status.Self.TailscaleIPs {
-		hostList = append(hostList, tailHost{
-			Name: sanitizeHost(status.Self.HostName),
-			IP:   ip,
-		})
+	if logDedup > 0 {
+		logOut = &dedupWriter{w: logOut, window: logDedup, seen: make(map[string]*dedupLine)}
 	}
-	for _, peer := range status.Peer {
-		if !peer.Online {
-			continue
-		}
+	log.SetOutput(logOut)
 
-		for _, ip := range peer.TailscaleIPs {
-			if peer.Tags == nil {
-				continue
-			}
-			for _, t := range peer.Tags.All() {
-				if dd.Tag != "" && t == dd.Tag {
-					hostList = append(hostList, tailHost{
-						Name: sanitizeHost(peer.HostName),
-						IP:   ip,
-					})
-				}
+	if !tsdns.ValidReplacement(dd.Separator) {
+		log.Fatalf("separator %q may only contain letters, digits, '-' and '_'", dd.Separator)
+	}
+	if dd.Flatten && len(tagSubdomains) > 0 {
+		log.Fatal("-tag-as-subdomain cannot be combined with -flatten")
+	}
+	if dd.Flatten && len(catchall) > 0 {
+		log.Fatal("-catchall cannot be combined with -flatten")
+	}
+	if len(dd.Domain) > 0 {
+		zone, err := tsdns.NormalizeZone(dd.Domain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dd.Domain = zone
+	}
+	domains := make(tsdns.DomainList, 0, len(subdomains))
+	for _, sub := range subdomains {
+		d := dd
+		d.Sub = sub
+		domains = append(domains, d)
+	}
+	if len(domains) == 0 {
+		domains = append(domains, dd)
+	}
+	for _, sz := range subdomainZones {
+		sub, zoneName, ok := strings.Cut(sz, "=")
+		if !ok {
+			log.Fatalf("invalid subdomain zone %q, expected subdomain=zone", sz)
+		}
+		zoneName, err := tsdns.NormalizeZone(zoneName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		found := false
+		for i := range domains {
+			if strings.EqualFold(domains[i].Sub, sub) {
+				domains[i].Zone = zoneName
+				found = true
 			}
 		}
+		if !found {
+			log.Fatalf("subdomain zone %q does not match any -subdomain", sz)
+		}
 	}
-
-	aliasList := make([]tailHost, 0)
-	for _, host := range hostList {
-		if aliases, ok := aliasMap[host.Name]; ok {
-			for _, a := range aliases {
-				aliasList = append(aliasList, tailHost{
-					Name: sanitizeHost(a),
-					IP:   host.IP,
-				})
+	for _, sub := range splitHorizon {
+		found := false
+		for i := range domains {
+			if strings.EqualFold(domains[i].Sub, sub) {
+				domains[i].External = true
+				found = true
 			}
 		}
+		if !found {
+			log.Fatalf("split-horizon subdomain %q does not match any -subdomain", sub)
+		}
 	}
-	hostList = append(hostList, aliasList...)
-
-	api, err := cloudflare.NewWithAPIToken(os.Getenv("CLOUDFLARE_API_TOKEN"))
-	if err != nil {
+	if len(externalOverride) > 0 && len(splitHorizon) == 0 {
+		log.Fatal("-external-override requires -split-horizon")
+	}
+	if perPage < 1 || perPage > tsdns.MaxPerPage {
+		log.Fatalf("cf-per-page must be between 1 and %d, got %d", tsdns.MaxPerPage, perPage)
+	}
+	if ipv6Format != "compressed" && ipv6Format != "expanded" {
+		log.Fatalf("ipv6-format must be compressed or expanded, got %q", ipv6Format)
+	}
+	// -ttl 0 leaves the ttl of existing records as is.
+	if err := tsdns.ValidateTTL(ttl); ttl != 0 && err != nil {
 		log.Fatal(err)
 	}
+	typeTTL := make(map[string]int)
+	for recordType, t := range map[string]int{"A": ttlA, "AAAA": ttlAAAA, "CNAME": ttlCNAME} {
+		if t == 0 {
+			continue
+		}
+		if err := tsdns.ValidateTTL(t); err != nil {
+			log.Fatalf("-ttl-%s: %v", strings.ToLower(recordType), err)
+		}
+		typeTTL[recordType] = t
+	}
+	if proxied && ttl != 1 && (flagSet("ttl") || fromEnv["ttl"]) {
+		log.Printf("proxied records always use automatic ttl, -ttl %d only applies to hosts unproxied in the config", ttl)
+	}
+	if exportFormat != "json" && exportFormat != "bind" && exportFormat != "terraform" {
+		log.Fatalf("format must be json, bind or terraform, got %q", exportFormat)
+	}
+	if len(deleteIDs) > 0 && !yes {
+		log.Fatal("-delete-id requires -yes")
+	}
+	if !tsdns.ValidInstanceID(instanceID) {
+		log.Fatalf("instance-id %q may only contain letters, digits, '-', '_' and '.'", instanceID)
+	}
+	if sinceBootstrap && len(stateFile) == 0 {
+		log.Fatal("-since-bootstrap requires -output-state")
+	}
+	if stateMaxAge < 0 {
+		log.Fatal("-state-max-age must not be negative")
+	}
+	if stateMaxAge > 0 && len(stateFile) == 0 && len(peerStateFile) == 0 {
+		log.Fatal("-state-max-age requires -output-state or -peer-state")
+	}
 
-	zoneID, err := api.ZoneIDByName(dd.Domain)
+	if !tsdns.ValidReplacement(sanitizeReplacement) {
+		log.Fatalf("sanitize-replacement %q may only contain letters, digits, '-' and '_'", sanitizeReplacement)
+	}
+	if !tsdns.ValidReplacement(mapIPToName) {
+		log.Fatalf("map-ip-to-name %q may only contain letters, digits, '-' and '_'", mapIPToName)
+	}
+	if len(mapIPToName) > 0 && len(tagTemplates) > 0 {
+		log.Fatal("-tag-template cannot be combined with -map-ip-to-name, which names records after their ip")
+	}
+	if len(hostsTXT) > 0 && !tsdns.ValidReplacement(hostsTXT) {
+		log.Fatalf("hosts-txt %q may only contain letters, digits, '-' and '_'", hostsTXT)
+	}
+	if !tsdns.ValidReplacement(endpointSuffix) {
+		log.Fatalf("endpoint-suffix %q may only contain letters, digits, '-' and '_'", endpointSuffix)
+	}
+	nameTmpl, err := tsdns.ParseNameTemplate(nameTemplate)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("invalid name template: %v", err)
+	}
+	tagTmpls := make(map[string]*template.Template)
+	for _, tt := range tagTemplates {
+		tag, text, ok := strings.Cut(tt, "=")
+		if !ok || !strings.HasPrefix(tag, "tag:") {
+			log.Fatalf("invalid tag template %q, expected tag:name=template", tt)
+		}
+		if tagTmpls[tag], err = tsdns.ParseNameTemplate(text); err != nil {
+			log.Fatalf("invalid template for %s: %v", tag, err)
+		}
+	}
+	var migrateTmpl *template.Template
+	if len(migrateFrom) > 0 {
+		if migrateTmpl, err = tsdns.ParseNameTemplate(migrateFrom); err != nil {
+			log.Fatalf("invalid migrate-from template: %v", err)
+		}
+	}
+	if selfTest {
+		hosts := flag.Args()
+		if len(hosts) == 0 {
+			hosts = tsdns.NameSamples
+		}
+		tsdns.PrintNames(os.Stdout, hosts, domains, nameTmpl, sanitizeReplacement)
+		return
 	}
 
-	currentRecords, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{})
-	if err != nil {
+	overrides := make(map[string][]tsdns.Host)
+	if len(overrideFile) > 0 {
+		hosts, err := tsdns.LoadOverrides(overrideFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, h := range hosts {
+			overrides[h.Name] = append(overrides[h.Name], h)
+		}
+	}
+	// -override replaces the file's entries for the same host.
+	inline := make(map[string][]tsdns.Host)
+	for _, o := range override {
+		h, err := tsdns.ParseOverride(o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		inline[h.Name] = append(inline[h.Name], h)
+	}
+	maps.Copy(overrides, inline)
+	externalOverrides := make(map[string][]tsdns.Host)
+	for _, o := range externalOverride {
+		h, err := tsdns.ParseOverride(o)
+		if err != nil {
+			log.Fatal(err)
+		}
+		externalOverrides[h.Name] = append(externalOverrides[h.Name], h)
+	}
+
+	attrs := make(map[string]string)
+	for _, a := range attrFilter {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || len(k) == 0 {
+			log.Fatalf("invalid attr %q, expected key=value", a)
+		}
+		attrs[k] = v
+	}
+
+	if len(statusFile) > 0 {
+		tsSource = "file"
+	}
+	var source tsdns.NodeSource
+	switch tsSource {
+	case "file":
+		if len(statusFile) == 0 {
+			log.Fatal("-tailscale-source file requires -status-file")
+		}
+		if len(group) > 0 {
+			log.Print("a tailscale status file does not report groups, -group is ignored")
+		}
+		source = tsdns.StatusFileSource{Path: statusFile}
+	case "local":
+		if len(attrs) > 0 {
+			log.Print("the local tailscale source does not report device attributes, -attr is ignored")
+		}
+		if len(group) > 0 {
+			log.Print("the local tailscale source does not report groups, -group is ignored")
+		}
+		source = tsdns.LocalSource{}
+	case "api":
+		ts := &tsdns.APISource{
+			BaseURL:      strings.TrimSuffix(tsAPIURL, "/"),
+			Tailnet:      tailnet,
+			Client:       &http.Client{Timeout: time.Minute},
+			APIKey:       os.Getenv("TS_API_KEY"),
+			ClientID:     os.Getenv("TS_OAUTH_CLIENT_ID"),
+			ClientSecret: os.Getenv("TS_OAUTH_CLIENT_SECRET"),
+			Attrs:        len(attrs) > 0,
+			Groups:       len(group) > 0,
+		}
+		if len(ts.APIKey) == 0 && (len(ts.ClientID) == 0 || len(ts.ClientSecret) == 0) {
+			log.Fatal("-tailscale-source api requires TS_API_KEY or TS_OAUTH_CLIENT_ID and TS_OAUTH_CLIENT_SECRET")
+		}
+		if len(endpointSuffix) > 0 {
+			log.Print("the tailscale api does not report peer endpoints, -endpoint-suffix is ignored")
+		}
+		if len(capability) > 0 {
+			log.Print("the tailscale api does not report node capabilities, -capability is ignored")
+		}
+		if exitNodesOnly {
+			log.Print("the tailscale api does not report exit nodes, -exit-nodes-only is ignored")
+			exitNodesOnly = false
+		}
+		source = ts
+	default:
+		log.Fatalf("unknown tailscale source %q", tsSource)
+	}
+
+	var adopt *regexp.Regexp
+	if len(adoptPattern) > 0 {
+		if adopt, err = regexp.Compile(adoptPattern); err != nil {
+			log.Fatalf("invalid adopt pattern: %v", err)
+		}
+	}
+
+	var cfg *tsdns.Config
+	if len(configFiles) > 0 {
+		if cfg, err = tsdns.LoadConfigs(configFiles); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	aliasMap := tsdns.ParseAliases(alias)
+	if err := tsdns.CheckAliasCycles(aliasMap); err != nil {
 		log.Fatal(err)
 	}
 
-	currentRecordMap := make(map[string]cloudflare.DNSRecord, len(currentRecords))
-	for _, r := range currentRecords {
-		currentRecordMap[strings.ToLower(r.Type+r.Name)] = r
+	// On SIGINT or SIGTERM no new record operations start; the ones in
+	// flight finish before the program exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &tsdns.Syncer{
+		Source:              source,
+		Tag:                 dd.Tag,
+		OSFilter:            osFilter,
+		Group:               group,
+		Attrs:               attrs,
+		Capability:          capability,
+		ExitNodesOnly:       exitNodesOnly,
+		SkipSelf:            skipSelf,
+		SelfRespectsTag:     selfRespectsTag,
+		AlwaysInclude:       alwaysInclude,
+		RequireSelfTag:      requireSelfTag,
+		MagicDNS:            magicDNS || len(magicDNSSuffix) > 0,
+		MagicDNSSuffix:      magicDNSSuffix,
+		ExcludeExpired:      excludeExpired,
+		WarnExpiring:        warnExpiring,
+		OrphanAfter:         orphanAfter,
+		AllowNonTailnetIP:   allowNonTailnetIP,
+		Limit:               peerLimit,
+		TagSubdomains:       tagSubdomains,
+		TagSubdomainEach:    tagSubdomainEach,
+		Aliases:             aliasMap,
+		Catchall:            catchall,
+		Overrides:           overrides,
+		ExternalOverrides:   externalOverrides,
+		EndpointSuffix:      endpointSuffix,
+		MapIPToName:         mapIPToName,
+		HostsTXT:            hostsTXT,
+		SanitizeReplacement: sanitizeReplacement,
+		TagTemplates:        tagTmpls,
+		MigrateFrom:         migrateTmpl,
+		NameTemplate:        nameTmpl,
+		TTL:                 ttl,
+		TypeTTL:             typeTTL,
+		Proxied:             proxied,
+		ProxiedTag:          proxiedTag,
+		ExpandIPv6:          ipv6Format == "expanded",
+		Config:              cfg,
+		PerPage:             perPage,
+		ClobberForeign:      clobberForeign,
+		OnlineThreshold:     onlineThreshold,
+		OfflineThreshold:    offlineThreshold,
+		PeerStateFile:       peerStateFile,
+		Adopt:               adopt,
+		Concurrency:         concurrency,
+		ZoneConcurrency:     zoneConcurrency,
+		RemoveOrphans:       removeUnused,
+		PruneOnly:           pruneOnly,
+		ProtectThreshold:    protectThreshold,
+		MaxCreates:          maxCreates,
+		SinceBootstrap:      sinceBootstrap,
+		StateMaxAge:         stateMaxAge,
+		StateFile:           stateFile,
+		ReportFile:          reportFile,
+		AuditLog:            auditLog,
+		InstanceID:          instanceID,
+		Replace:             replace,
+		Batch:               batch,
+		DryRun:              dryRun,
+		WatchJitter:         watchJitter,
+		WatchErrorInterval:  errorInterval,
+		Verbose:             verbose,
 	}
 
-	if removeAll {
-		for _, r := range currentRecords {
-			if (r.Type == "A" || r.Type == "AAAA") && strings.HasSuffix(r.Name, dd.String()) {
-				log.Printf("removing record with name %s, ip %s", r.Name, r.Content)
-				if err := api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), r.ID); err != nil {
-					log.Fatal(err)
-				}
-			}
+	if explain {
+		if err := s.Explain(ctx, os.Stdout); err != nil {
+			log.Fatal(err)
 		}
 		return
 	}
 
-	tHostMap := make(map[string]struct{}, len(hostList))
-	for _, t := range hostList {
-		recordType := t.RecordType()
-		recordName := dd.BuildHostname(t.Name)
-		cfDnsRecord := cloudflare.UpdateDNSRecordParams{
-			Type:    recordType,
-			Name:    recordName,
-			Content: t.IP.String(),
-			TTL:     1,
-		}
-		action := "updated"
-		var err error
-		if _, exists := currentRecordMap[strings.ToLower(recordType+recordName)]; exists {
-			cfDnsRecord := cloudflare.UpdateDNSRecordParams{
-				Type:    recordType,
-				Name:    recordName,
-				Content: t.IP.String(),
-				TTL:     1,
-				ID:      currentRecordMap[strings.ToLower(recordType+recordName)].ID,
+	if rateLimit <= 0 {
+		log.Fatalf("cf-rate-limit must be positive, got %v", rateLimit)
+	}
+	if onlineThreshold < 1 || offlineThreshold < 1 {
+		log.Fatal("online-threshold and offline-threshold must be at least 1")
+	}
+	if concurrency < 1 || zoneConcurrency < 1 {
+		log.Fatal("concurrency and zone-concurrency must be at least 1")
+	}
+	cfOpts := []cloudflare.Option{cloudflare.UsingRateLimit(rateLimit)}
+	if len(cfBaseURL) > 0 {
+		cfOpts = append(cfOpts, cloudflare.BaseURL(cfBaseURL))
+	}
+	// With -records-file nothing talks to cloudflare, so no token is needed.
+	var fixture []cloudflare.DNSRecord
+	var api *cloudflare.API
+	if len(recordsFile) > 0 {
+		if probeOnly || list || removeAll || watch > 0 || len(deleteIDs) > 0 || len(exportFile) > 0 {
+			log.Fatal("-records-file only works with a single sync")
+		}
+		if fixture, err = tsdns.LoadRecords(recordsFile); err != nil {
+			log.Fatal(err)
+		}
+		if fixture == nil {
+			fixture = []cloudflare.DNSRecord{}
+		}
+		s.DryRun = true
+	} else if api, err = cloudflare.NewWithAPIToken(os.Getenv("CLOUDFLARE_API_TOKEN"), cfOpts...); err != nil {
+		log.Fatal(err)
+	}
+
+	s.API = api
+	s.Fixture = fixture
+
+	// Without -zone, a token scoped to a single zone selects it.
+	if len(dd.Domain) == 0 {
+		if api == nil {
+			log.Fatal("-zone is required with -records-file")
+		}
+		accessible, err := api.ListZones(ctx)
+		if err != nil {
+			log.Fatalf("unable to list zones: %v", err)
+		}
+		if len(accessible) != 1 {
+			names := make([]string, 0, len(accessible))
+			for _, z := range accessible {
+				names = append(names, z.Name)
 			}
-			_, err = api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cfDnsRecord)
-		} else {
-			cfDnsRecord := cloudflare.CreateDNSRecordParams{
-				Type:    recordType,
-				Name:    recordName,
-				Content: t.IP.String(),
-				TTL:     1,
+			log.Fatalf("-zone is required, the token can access %d zones: %s", len(accessible), strings.Join(names, ", "))
+		}
+		log.Printf("using zone %s, the only zone the token can access", accessible[0].Name)
+		for i := range domains {
+			domains[i].Domain = accessible[0].Name
+		}
+	}
+
+	if probeOnly {
+		var zoneNames []string
+		for _, d := range domains {
+			if !slices.Contains(zoneNames, d.ZoneName()) {
+				zoneNames = append(zoneNames, d.ZoneName())
 			}
-			action = "created"
-			_, err = api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cfDnsRecord)
 		}
-		if err != nil {
-			log.Fatalf("unable to create record %v. err: %v", cfDnsRecord, err)
+		if !tsdns.Probe(ctx, os.Stdout, source, api, zoneNames) {
+			os.Exit(1)
 		}
-		log.Printf("%s dns record type %s, host %s, ip %s", action, recordType, recordName, t.IP)
-		tHostMap[strings.ToLower(recordType+recordName)] = struct{}{}
+		return
 	}
 
-	if removeUnused {
-		for _, r := range currentRecordMap {
-			if strings.HasSuffix(r.Name, dd.String()) {
-				if _, exists := tHostMap[strings.ToLower(r.Type+r.Name)]; !exists {
-					log.Printf("removing record with name %s, ip %s", r.Name, r.Content)
-					if err := api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), r.ID); err != nil {
-						log.Fatal(err)
+	var zones []tsdns.Zone
+	zoneIndex := make(map[string]int)
+	for _, d := range domains {
+		name := d.ZoneName()
+		if !strings.HasSuffix(d.BuildHostname("host"), "."+name) {
+			log.Fatalf("records under %s are not within zone %s", d.MatchSuffix(), name)
+		}
+		i, ok := zoneIndex[name]
+		if !ok {
+			zoneID := name
+			if fixture == nil {
+				if zoneID, err = api.ZoneIDByName(name); err != nil {
+					if label, parent, _ := strings.Cut(name, "."); strings.Contains(parent, ".") {
+						log.Fatalf("unable to find zone %s: %v; if %s is a name within zone %s, use -zone %s -subdomain %s", name, err, name, parent, parent, label)
 					}
+					log.Fatalf("unable to find zone %s: %v", name, err)
 				}
 			}
+			zones = append(zones, tsdns.Zone{ID: zoneID, Name: name})
+			i = len(zones) - 1
+			zoneIndex[name] = i
+		}
+		zones[i].Domains = append(zones[i].Domains, d)
+	}
+	s.Zones = zones
+
+	// A read-only token turns a sync into a dry run instead of failing on
+	// its first change.
+	if api != nil && !s.DryRun && !list && len(exportFile) == 0 {
+		for _, z := range zones {
+			writable, err := tsdns.CanWrite(ctx, api, z.ID)
+			if err != nil {
+				log.Fatalf("unable to check write access to zone %s: %v", z.Name, err)
+			}
+			if writable {
+				continue
+			}
+			if removeAll || len(deleteIDs) > 0 {
+				log.Fatalf("the cloudflare token cannot change records in zone %s", z.Name)
+			}
+			log.Printf("warning: the cloudflare token cannot change records in zone %s, only reporting changes as with -dry-run", z.Name)
+			s.DryRun = true
+			break
+		}
+	}
+
+	if len(metricsAddr) > 0 {
+		s.Metrics = tsdns.NewMetrics()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.Metrics)
+		go func() {
+			log.Fatal(http.ListenAndServe(metricsAddr, mux))
+		}()
+	}
+
+	switch {
+	case len(deleteIDs) > 0:
+		if err := s.DeleteByID(ctx, deleteIDs); err != nil {
+			log.Fatal(err)
+		}
+	case len(exportFile) > 0:
+		out := os.Stdout
+		if exportFile != "-" {
+			f, err := os.Create(exportFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := s.Export(ctx, out, exportFormat); err != nil {
+			log.Fatal(err)
+		}
+	case list:
+		var currentRecords []cloudflare.DNSRecord
+		for _, z := range zones {
+			records, err := tsdns.ListZoneRecords(ctx, api, z, perPage)
+			if err != nil {
+				log.Fatal(err)
+			}
+			currentRecords = append(currentRecords, records...)
+		}
+		tsdns.SortRecords(currentRecords)
+		tsdns.PrintRecords(os.Stdout, currentRecords, domains)
+	case removeAll:
+		if err := s.RemoveAll(ctx); err != nil {
+			log.Fatal(err)
+		}
+	case watch > 0:
+		s.Watch(ctx, watch)
+	default:
+		if err := s.RunOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatal(err)
 		}
 	}
 }
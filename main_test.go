@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"testing"
+)
+
+func TestSetFromEnvRepeatable(t *testing.T) {
+	defer func(cl *flag.FlagSet) { flag.CommandLine = cl }(flag.CommandLine)
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{"nas=files,backup", []string{"nas=files,backup"}},
+		{"nas=files,backup;web=www", []string{"nas=files,backup", "web=www"}},
+		{"nas=files;;web=www;", []string{"nas=files", "web=www"}},
+	}
+	for _, tt := range tests {
+		flag.CommandLine = flag.NewFlagSet("test", flag.ContinueOnError)
+		var alias arrayFlags
+		flag.Var(&alias, "alias", "")
+		t.Setenv(envPrefix+"ALIAS", tt.env)
+		setFromEnv()
+		if !slices.Equal(alias, tt.want) {
+			t.Errorf("%s=%q: alias = %q, want %q", envPrefix+"ALIAS", tt.env, alias, tt.want)
+		}
+	}
+}
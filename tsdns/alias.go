@@ -0,0 +1,93 @@
+package tsdns
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ParseAliases parses -alias values of the form host=a,b,c. Whitespace around
+// names is trimmed, empty alias names are dropped and malformed values are
+// logged and ignored.
+func ParseAliases(flags []string) map[string][]string {
+	aliasMap := make(map[string][]string, 0)
+	for _, a := range flags {
+		host, list, ok := strings.Cut(a, "=")
+		host = strings.TrimSpace(host)
+		if !ok || len(host) == 0 {
+			log.Printf("ignoring malformed alias %q, expected host=alias[,alias...]", a)
+			continue
+		}
+		var aliases []string
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				aliases = append(aliases, name)
+			}
+		}
+		if len(aliases) == 0 {
+			log.Printf("ignoring alias %q without alias names", a)
+			continue
+		}
+		aliasMap[host] = aliases
+	}
+	return aliasMap
+}
+
+// CheckAliasCycles returns an error describing the first cycle found in the
+// alias definitions, e.g. a=b and b=a.
+func CheckAliasCycles(aliasMap map[string][]string) error {
+	const (
+		visiting = iota + 1
+		done
+	)
+	state := make(map[string]int, len(aliasMap))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("alias cycle: %s", strings.Join(append(path, name), " -> "))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, a := range aliasMap[name] {
+			if err := visit(a, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+
+	hosts := make([]string, 0, len(aliasMap))
+	for host := range aliasMap {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		if err := visit(host, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveAliases returns every alias reachable from host, following aliases
+// that are themselves aliased. aliasMap must not contain cycles.
+func resolveAliases(aliasMap map[string][]string, host string) []string {
+	var resolved []string
+	seen := make(map[string]struct{})
+	queue := aliasMap[host]
+	for len(queue) > 0 {
+		a := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[a]; ok {
+			continue
+		}
+		seen[a] = struct{}{}
+		resolved = append(resolved, a)
+		queue = append(queue, aliasMap[a]...)
+	}
+	return resolved
+}
@@ -0,0 +1,125 @@
+package tsdns
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveAliasesChain(t *testing.T) {
+	aliases := map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"d"}}
+	if err := CheckAliasCycles(aliases); err != nil {
+		t.Fatalf("CheckAliasCycles: %v", err)
+	}
+	if got, want := resolveAliases(aliases, "a"), []string{"b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("resolveAliases(a) = %v, want %v", got, want)
+	}
+	if got := resolveAliases(aliases, "d"); len(got) > 0 {
+		t.Errorf("resolveAliases(d) = %v, want none", got)
+	}
+}
+
+func TestCheckAliasCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases map[string][]string
+		want    string
+	}{
+		{"none", map[string][]string{"a": {"b", "c"}, "b": {"c"}}, ""},
+		{"self", map[string][]string{"a": {"a"}}, "alias cycle: a -> a"},
+		{"pair", map[string][]string{"a": {"b"}, "b": {"a"}}, "alias cycle: a -> b -> a"},
+		{"three deep", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}, "alias cycle: a -> b -> c -> a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAliasCycles(tt.aliases)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("CheckAliasCycles: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("CheckAliasCycles = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunOnceChainedAliases(t *testing.T) {
+	api := newFakeDNS()
+	s := testSyncer(api, peer("a", "100.64.0.5"))
+	s.Aliases = map[string][]string{"a": {"web"}, "web": {"www"}, "www": {"site"}}
+	runOnce(t, s)
+	for _, name := range []string{"a", "web", "www", "site"} {
+		records := api.byName(name + ".wg.example.com")
+		if len(records) != 1 || records[0].Content != "100.64.0.5" {
+			t.Errorf("records of %s = %+v, want one A record for 100.64.0.5", name, records)
+		}
+	}
+}
+
+func TestParseAliases(t *testing.T) {
+	tests := []struct {
+		flags []string
+		want  map[string][]string
+	}{
+		{[]string{"host="}, map[string][]string{}},
+		{[]string{"=a"}, map[string][]string{}},
+		{[]string{"host"}, map[string][]string{}},
+		{[]string{"host=a,,b"}, map[string][]string{"host": {"a", "b"}}},
+		{[]string{" host = a , b ,"}, map[string][]string{"host": {"a", "b"}}},
+		{[]string{"host=,", "other=c"}, map[string][]string{"other": {"c"}}},
+	}
+	for _, tt := range tests {
+		got := ParseAliases(tt.flags)
+		if !maps.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("ParseAliases(%q) = %v, want %v", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestRunOnceRemovedAliasIsSwept(t *testing.T) {
+	api := newFakeDNS()
+	s := testSyncer(api, peer("a", "100.64.0.5"))
+	s.StateFile = filepath.Join(t.TempDir(), "state.json")
+	s.Aliases = map[string][]string{"a": {"web", "site"}}
+	runOnce(t, s)
+	web := api.byName("web.wg.example.com")
+	if len(web) != 1 {
+		t.Fatalf("records of web = %+v, want one", web)
+	}
+	api.reset()
+
+	s.Aliases = map[string][]string{"a": {"site"}}
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	if calls := api.reset(); !slices.Equal(calls, []string{"delete " + web[0].ID}) {
+		t.Errorf("calls = %v, want only the removed alias deleted", calls)
+	}
+	for _, name := range []string{"a", "site"} {
+		if records := api.byName(name + ".wg.example.com"); len(records) != 1 {
+			t.Errorf("records of %s = %+v, want one", name, records)
+		}
+	}
+}
+
+func TestHostsAliasNamedLikeHost(t *testing.T) {
+	s := testSyncer(newFakeDNS(), peer("a", "100.64.0.5"), peer("b", "100.64.0.6"))
+	s.Aliases = map[string][]string{"a": {"b", "web"}}
+	hosts, _, err := s.Hosts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, h := range hosts {
+		got[h.Name] = append(got[h.Name], h.IP.String())
+	}
+	want := map[string][]string{"a": {"100.64.0.5"}, "b": {"100.64.0.6"}, "web": {"100.64.0.5"}}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("hosts = %v, want %v", got, want)
+	}
+}
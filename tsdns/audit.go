@@ -0,0 +1,62 @@
+package tsdns
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// auditEntry is one line of the AuditLog.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Zone       string    `json:"zone"`
+	Action     string    `json:"action"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	OldContent string    `json:"old_content,omitempty"`
+	NewContent string    `json:"new_content,omitempty"`
+	ID         string    `json:"id,omitempty"`
+}
+
+// audit appends an applied change to AuditLog. The change is already made,
+// so a failed write is logged rather than failing the pass.
+func (s *Syncer) audit(zone, action, recordType, name, oldContent, newContent, id string) {
+	if len(s.AuditLog) == 0 {
+		return
+	}
+	b, err := json.Marshal(auditEntry{
+		Time:       time.Now().UTC(),
+		Zone:       zone,
+		Action:     action,
+		Type:       recordType,
+		Name:       name,
+		OldContent: oldContent,
+		NewContent: newContent,
+		ID:         id,
+	})
+	if err != nil {
+		log.Printf("unable to write audit log %s: %v", s.AuditLog, err)
+		return
+	}
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	f, err := os.OpenFile(s.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("unable to write audit log %s: %v", s.AuditLog, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log.Printf("unable to write audit log %s: %v", s.AuditLog, err)
+	}
+}
+
+// auditOp records an applied op with AuditLog.
+func (s *Syncer) auditOp(zone string, op recordOp, id string) {
+	newContent := op.record.Content
+	if op.action == "removed" {
+		newContent = ""
+	}
+	s.audit(zone, op.action, op.record.Type, op.record.Name, op.old, newContent, id)
+}
@@ -0,0 +1,117 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// maxBatchSize is the most operations sent in one batch request. Cloudflare
+// allows more on paid plans.
+const maxBatchSize = 200
+
+// batchRecord is a record in a batch request. Patches carry the id.
+type batchRecord struct {
+	ID       string                       `json:"id,omitempty"`
+	Type     string                       `json:"type"`
+	Name     string                       `json:"name"`
+	Content  string                       `json:"content"`
+	TTL      int                          `json:"ttl,omitempty"`
+	Proxied  *bool                        `json:"proxied,omitempty"`
+	Comment  string                       `json:"comment"`
+	Tags     []string                     `json:"tags"`
+	Priority *uint16                      `json:"priority,omitempty"`
+	Settings cloudflare.DNSRecordSettings `json:"settings"`
+	Data     any                          `json:"data,omitempty"`
+}
+
+type batchID struct {
+	ID string `json:"id"`
+}
+
+type batchRequest struct {
+	Deletes []batchID     `json:"deletes,omitempty"`
+	Patches []batchRecord `json:"patches,omitempty"`
+	Posts   []batchRecord `json:"posts,omitempty"`
+}
+
+type batchResult struct {
+	Deletes []cloudflare.DNSRecord `json:"deletes"`
+	Patches []cloudflare.DNSRecord `json:"patches"`
+	Posts   []cloudflare.DNSRecord `json:"posts"`
+}
+
+func newBatchRecord(r cloudflare.DNSRecord) batchRecord {
+	return batchRecord{
+		ID:       r.ID,
+		Type:     r.Type,
+		Name:     r.Name,
+		Content:  r.Content,
+		TTL:      r.TTL,
+		Proxied:  r.Proxied,
+		Comment:  r.Comment,
+		Tags:     r.Tags,
+		Priority: r.Priority,
+		Settings: r.Settings,
+		Data:     r.Data,
+	}
+}
+
+// applyBatch applies ops in a single batch request, which cloudflare runs
+// atomically: deletes first, then patches, then posts. It returns the
+// resulting record of every op, in order.
+func (s *Syncer) applyBatch(ctx context.Context, z Zone, ops []recordOp) ([]cloudflare.DNSRecord, error) {
+	var req batchRequest
+	for _, op := range ops {
+		r := op.record
+		switch op.action {
+		case "created":
+			post := newBatchRecord(r)
+			post.ID = ""
+			req.Posts = append(req.Posts, post)
+		case "updated", "adopted", "migrated":
+			req.Patches = append(req.Patches, newBatchRecord(r))
+		case "replaced":
+			req.Deletes = append(req.Deletes, batchID{ID: r.ID})
+			post := newBatchRecord(r)
+			post.ID = ""
+			req.Posts = append(req.Posts, post)
+		case "removed":
+			req.Deletes = append(req.Deletes, batchID{ID: r.ID})
+		default:
+			return nil, fmt.Errorf("unknown record action %q", op.action)
+		}
+	}
+
+	raw, err := s.API.Raw(ctx, http.MethodPost, "/zones/"+z.ID+"/dns_records/batch", req, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res batchResult
+	if err := json.Unmarshal(raw.Result, &res); err != nil {
+		return nil, fmt.Errorf("invalid batch response: %w", err)
+	}
+	if len(res.Posts) != len(req.Posts) || len(res.Patches) != len(req.Patches) {
+		return nil, fmt.Errorf("batch response has %d posts and %d patches, sent %d and %d", len(res.Posts), len(res.Patches), len(req.Posts), len(req.Patches))
+	}
+
+	results := make([]cloudflare.DNSRecord, len(ops))
+	posts, patches := res.Posts, res.Patches
+	for i, op := range ops {
+		switch op.action {
+		case "created", "replaced":
+			results[i], posts = posts[0], posts[1:]
+		case "updated", "adopted", "migrated":
+			results[i], patches = patches[0], patches[1:]
+		case "removed":
+			results[i] = op.record
+		}
+		r := op.record
+		log.Printf("%s dns record type %s, host %s, content %s, id %s", op.action, r.Type, r.Name, r.Content, results[i].ID)
+	}
+	return results, nil
+}
@@ -0,0 +1,22 @@
+package tsdns
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// DNSClient is the part of the cloudflare api a Syncer uses. *cloudflare.API
+// implements it; embedders can wrap it or pass their own provider.
+type DNSClient interface {
+	ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+	GetDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) (cloudflare.DNSRecord, error)
+	CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error)
+	UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error)
+	DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error
+	// Raw sends the requests the client has no method for.
+	Raw(ctx context.Context, method, endpoint string, data interface{}, headers http.Header) (cloudflare.RawResponse, error)
+}
+
+var _ DNSClient = (*cloudflare.API)(nil)
@@ -0,0 +1,175 @@
+package tsdns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// cfRequest is a request received by the mock cloudflare api.
+type cfRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Body   map[string]any
+}
+
+// mockCloudflare serves the dns record endpoints of the cloudflare api from
+// records and logs every request it receives.
+type mockCloudflare struct {
+	mu       sync.Mutex
+	records  map[string]cloudflare.DNSRecord
+	requests []cfRequest
+}
+
+func newMockCloudflare(t *testing.T, records ...cloudflare.DNSRecord) (*mockCloudflare, *cloudflare.API) {
+	m := &mockCloudflare{records: make(map[string]cloudflare.DNSRecord)}
+	for _, r := range records {
+		m.records[r.ID] = r
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /zones/{zone}/dns_records", m.list)
+	mux.HandleFunc("POST /zones/{zone}/dns_records", m.create)
+	mux.HandleFunc("PATCH /zones/{zone}/dns_records/{id}", m.update)
+	mux.HandleFunc("DELETE /zones/{zone}/dns_records/{id}", m.delete)
+	srv := httptest.NewServer(m.logged(mux))
+	t.Cleanup(srv.Close)
+
+	api, err := cloudflare.NewWithAPIToken("token", cloudflare.BaseURL(srv.URL), cloudflare.UsingRateLimit(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, api
+}
+
+func (m *mockCloudflare) logged(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := cfRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery}
+		if r.Body != nil {
+			json.NewDecoder(r.Body).Decode(&req.Body)
+		}
+		m.mu.Lock()
+		m.requests = append(m.requests, req)
+		m.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// find returns the logged requests with method.
+func (m *mockCloudflare) find(method string) []cfRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var found []cfRequest
+	for _, r := range m.requests {
+		if r.Method == method {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func respond(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":  true,
+		"errors":   []any{},
+		"messages": []any{},
+		"result":   result,
+	})
+}
+
+func (m *mockCloudflare) list(w http.ResponseWriter, r *http.Request) {
+	suffix := r.URL.Query().Get("name.endswith")
+	m.mu.Lock()
+	records := make([]cloudflare.DNSRecord, 0, len(m.records))
+	for _, rec := range m.records {
+		if strings.HasSuffix(rec.Name, suffix) {
+			records = append(records, rec)
+		}
+	}
+	m.mu.Unlock()
+	SortRecords(records)
+	respond(w, records)
+}
+
+func (m *mockCloudflare) create(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	body := m.requests[len(m.requests)-1].Body
+	rec := cloudflare.DNSRecord{
+		ID:      "new-" + body["name"].(string),
+		Type:    body["type"].(string),
+		Name:    body["name"].(string),
+		Content: body["content"].(string),
+		TTL:     int(body["ttl"].(float64)),
+		Comment: body["comment"].(string),
+	}
+	m.records[rec.ID] = rec
+	respond(w, rec)
+}
+
+func (m *mockCloudflare) update(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.records[r.PathValue("id")]
+	if !ok {
+		http.Error(w, `{"success":false,"errors":[{"code":81044,"message":"Record does not exist."}]}`, http.StatusNotFound)
+		return
+	}
+	rec.Content = m.requests[len(m.requests)-1].Body["content"].(string)
+	m.records[rec.ID] = rec
+	respond(w, rec)
+}
+
+func (m *mockCloudflare) delete(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, r.PathValue("id"))
+	respond(w, map[string]string{"id": r.PathValue("id")})
+}
+
+// TestRunOnceCloudflareRequests checks the requests a sync sends through the
+// cloudflare client: a filtered list, then a create, an update and a delete.
+func TestRunOnceCloudflareRequests(t *testing.T) {
+	m, api := newMockCloudflare(t,
+		cloudflare.DNSRecord{ID: "id-a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: DefaultTTL, Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "id-gone", Type: "A", Name: "gone.wg.example.com", Content: "100.64.0.8", TTL: DefaultTTL, Comment: ownerMarker},
+	)
+	s := testSyncer(api, peer("a", "100.64.0.5"), peer("b", "100.64.0.6"))
+	s.RemoveOrphans = true
+	runOnce(t, s)
+
+	lists := m.find(http.MethodGet)
+	if len(lists) == 0 || lists[0].Path != "/zones/zone1/dns_records" || !strings.Contains(lists[0].Query, "name.endswith=wg.example.com") {
+		t.Errorf("list requests = %+v, want a name.endswith=wg.example.com list of zone1", lists)
+	}
+
+	posts := m.find(http.MethodPost)
+	if len(posts) != 1 {
+		t.Fatalf("got %d create requests, want 1: %+v", len(posts), posts)
+	}
+	want := map[string]any{"type": "A", "name": "b.wg.example.com", "content": "100.64.0.6", "ttl": float64(DefaultTTL), "proxied": false, "comment": ownerMarker}
+	for k, v := range want {
+		if posts[0].Body[k] != v {
+			t.Errorf("create %s = %v, want %v", k, posts[0].Body[k], v)
+		}
+	}
+
+	patches := m.find(http.MethodPatch)
+	if len(patches) != 1 || patches[0].Path != "/zones/zone1/dns_records/id-a" {
+		t.Fatalf("update requests = %+v, want one for id-a", patches)
+	}
+	if patches[0].Body["content"] != "100.64.0.5" || patches[0].Body["type"] != "A" || patches[0].Body["comment"] != ownerMarker {
+		t.Errorf("update body = %v, want type A, content 100.64.0.5 and the owner comment", patches[0].Body)
+	}
+
+	deletes := m.find(http.MethodDelete)
+	if len(deletes) != 1 || deletes[0].Path != "/zones/zone1/dns_records/id-gone" {
+		t.Errorf("delete requests = %+v, want one for id-gone", deletes)
+	}
+}
@@ -0,0 +1,238 @@
+package tsdns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Config holds per host settings, usually read from a json file with
+// LoadConfig.
+type Config struct {
+	// Hosts is keyed by the host part of the record name, after
+	// -name-template is applied. Alias names can be used as keys too.
+	Hosts map[string]HostConfig `json:"hosts"`
+	// Records are static records managed alongside the hosts' records.
+	Records []StaticRecord `json:"records"`
+}
+
+// StaticRecord is a record from the config. Name is the host part like the
+// Hosts keys, so it is built under every domain.
+type StaticRecord struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// host returns r as a Host. validate must accept r.
+func (r StaticRecord) host() Host {
+	h := Host{Name: strings.ToLower(r.Name), Type: strings.ToUpper(r.Type)}
+	if ip, err := netip.ParseAddr(r.Content); err == nil && (h.Type == "A" || h.Type == "AAAA") {
+		h.IP = ip
+		return h
+	}
+	h.Target = r.Content
+	return h
+}
+
+func (r StaticRecord) validate() error {
+	if len(r.Type) == 0 || len(r.Name) == 0 || len(r.Content) == 0 {
+		return fmt.Errorf("record %+v needs a type, name and content", r)
+	}
+	switch t := strings.ToUpper(r.Type); t {
+	case "A", "AAAA":
+		ip, err := netip.ParseAddr(r.Content)
+		if err != nil || ip.Is4() != (t == "A") {
+			return fmt.Errorf("record %s: content %q is not an %s address", r.Name, r.Content, t)
+		}
+	case "NS", "SOA":
+		return fmt.Errorf("record %s: %s records cannot be managed", r.Name, t)
+	}
+	return nil
+}
+
+// HostConfig is the configuration of a single host.
+type HostConfig struct {
+	// Enabled false removes the host's records instead of creating them.
+	Enabled *bool         `json:"enabled"`
+	Record  RecordOptions `json:"record"`
+}
+
+// RecordOptions are extra cloudflare record fields passed through on create
+// and update.
+type RecordOptions struct {
+	Comment  string   `json:"comment"`
+	Tags     []string `json:"tags"`
+	Priority *uint16  `json:"priority"`
+	// Proxied overrides Syncer.Proxied for the host.
+	Proxied  *bool                        `json:"proxied"`
+	Data     map[string]any               `json:"data"`
+	Settings cloudflare.DNSRecordSettings `json:"settings"`
+}
+
+// LoadConfig reads a json config file. Unknown keys are rejected so typos
+// don't go unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Config{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	for _, r := range c.Records {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+	}
+	return c, nil
+}
+
+// LoadConfigs reads the config files in order and merges them, later files
+// overriding earlier ones.
+func LoadConfigs(paths []string) (*Config, error) {
+	c := &Config{Hosts: make(map[string]HostConfig)}
+	for _, path := range paths {
+		next, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		c.merge(next)
+	}
+	return c, nil
+}
+
+// merge applies other on top of c. Hosts merge by name and their record
+// options field by field: set fields in other replace those in c, and data
+// merges by key. Tags are replaced as a whole.
+func (c *Config) merge(other *Config) {
+	if other.Records != nil {
+		c.Records = other.Records
+	}
+	for name, h := range other.Hosts {
+		cur := c.Hosts[name]
+		if h.Enabled != nil {
+			cur.Enabled = h.Enabled
+		}
+		o := h.Record
+		if len(o.Comment) > 0 {
+			cur.Record.Comment = o.Comment
+		}
+		if o.Tags != nil {
+			cur.Record.Tags = o.Tags
+		}
+		if o.Priority != nil {
+			cur.Record.Priority = o.Priority
+		}
+		if o.Proxied != nil {
+			cur.Record.Proxied = o.Proxied
+		}
+		if o.Settings.FlattenCNAME != nil {
+			cur.Record.Settings.FlattenCNAME = o.Settings.FlattenCNAME
+		}
+		if len(o.Data) > 0 {
+			data := make(map[string]any, len(cur.Record.Data)+len(o.Data))
+			for k, v := range cur.Record.Data {
+				data[k] = v
+			}
+			for k, v := range o.Data {
+				data[k] = v
+			}
+			cur.Record.Data = data
+		}
+		c.Hosts[name] = cur
+	}
+}
+
+// HostOptions returns the configured record options for host, if any.
+func (c *Config) HostOptions(host string) RecordOptions {
+	if c == nil {
+		return RecordOptions{}
+	}
+	return c.Hosts[host].Record
+}
+
+// staticHosts returns the configured static records.
+func (c *Config) staticHosts() []Host {
+	if c == nil {
+		return nil
+	}
+	hosts := make([]Host, 0, len(c.Records))
+	for _, r := range c.Records {
+		hosts = append(hosts, r.host())
+	}
+	return hosts
+}
+
+// HostEnabled reports whether host may have records. Hosts are enabled
+// unless the config says otherwise.
+func (c *Config) HostEnabled(host string) bool {
+	if c == nil {
+		return true
+	}
+	enabled := c.Hosts[host].Enabled
+	return enabled == nil || *enabled
+}
+
+// upToDate reports whether r already has the configured options and the
+// given comment, which carries the owner marker. Options that are not set are
+// not compared, and data only by the configured keys.
+func (o RecordOptions) upToDate(r cloudflare.DNSRecord, comment string) bool {
+	if r.Comment != comment {
+		return false
+	}
+	if len(o.Tags) > 0 {
+		want := slices.Clone(o.Tags)
+		have := slices.Clone(r.Tags)
+		slices.Sort(want)
+		slices.Sort(have)
+		if !slices.Equal(want, have) {
+			return false
+		}
+	}
+	if o.Priority != nil && (r.Priority == nil || *o.Priority != *r.Priority) {
+		return false
+	}
+	if o.Settings.FlattenCNAME != nil && (r.Settings.FlattenCNAME == nil || *o.Settings.FlattenCNAME != *r.Settings.FlattenCNAME) {
+		return false
+	}
+	return dataUpToDate(o.Data, r.Data)
+}
+
+// dataUpToDate reports whether have, a record's data, holds every key of want
+// with the same value. Values are compared by their json encoding, so numbers
+// read from the config and from the api compare equal.
+func dataUpToDate(want map[string]any, have any) bool {
+	if len(want) == 0 {
+		return true
+	}
+	b, err := json.Marshal(have)
+	if err != nil {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return false
+	}
+	for k, v := range want {
+		w, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		h, ok := fields[k]
+		if !ok || string(w) != string(h) {
+			return false
+		}
+	}
+	return true
+}
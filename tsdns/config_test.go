@@ -0,0 +1,157 @@
+package tsdns
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestRecordOptionsUpToDate(t *testing.T) {
+	yes, no := true, false
+	prio := uint16(10)
+	tests := []struct {
+		name string
+		opts RecordOptions
+		r    cloudflare.DNSRecord
+		want bool
+	}{
+		{"nothing set", RecordOptions{}, cloudflare.DNSRecord{Data: map[string]any{"port": 80}}, true},
+		{"tags in any order", RecordOptions{Tags: []string{"b:1", "a:1"}}, cloudflare.DNSRecord{Tags: []string{"a:1", "b:1"}}, true},
+		{"tags differ", RecordOptions{Tags: []string{"a:1"}}, cloudflare.DNSRecord{Tags: []string{"a:2"}}, false},
+		{"priority missing", RecordOptions{Priority: &prio}, cloudflare.DNSRecord{}, false},
+		{"flatten cname differs", RecordOptions{Settings: cloudflare.DNSRecordSettings{FlattenCNAME: &yes}}, cloudflare.DNSRecord{Settings: cloudflare.DNSRecordSettings{FlattenCNAME: &no}}, false},
+		{"flatten cname same", RecordOptions{Settings: cloudflare.DNSRecordSettings{FlattenCNAME: &yes}}, cloudflare.DNSRecord{Settings: cloudflare.DNSRecordSettings{FlattenCNAME: &yes}}, true},
+		{"data same", RecordOptions{Data: map[string]any{"port": float64(80)}}, cloudflare.DNSRecord{Data: map[string]any{"port": 80, "weight": 5}}, true},
+		{"data differs", RecordOptions{Data: map[string]any{"port": float64(80)}}, cloudflare.DNSRecord{Data: map[string]any{"port": 443}}, false},
+		{"data missing", RecordOptions{Data: map[string]any{"port": float64(80)}}, cloudflare.DNSRecord{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.upToDate(tt.r, ""); got != tt.want {
+				t.Errorf("upToDate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunOnceProxiedOverride(t *testing.T) {
+	api := newFakeDNS()
+	s := testSyncer(api, peer("a", "100.64.0.5"), peer("b", "100.64.0.6"))
+	runOnce(t, s)
+	api.reset()
+
+	for _, proxied := range []bool{true, false} {
+		s.Config = &Config{Hosts: map[string]HostConfig{"a": {Record: RecordOptions{Proxied: &proxied}}}}
+		runOnce(t, s)
+		calls := api.reset()
+		if a := api.byName("a.wg.example.com"); len(calls) != 1 || calls[0] != "update "+a[0].ID {
+			t.Errorf("proxied %v: calls = %v, want one update of a", proxied, calls)
+		}
+		if a := api.byName("a.wg.example.com"); *a[0].Proxied != proxied {
+			t.Errorf("a proxied = %v, want %v", *a[0].Proxied, proxied)
+		}
+		if b := api.byName("b.wg.example.com"); *b[0].Proxied {
+			t.Error("b is proxied, want the global default")
+		}
+		runOnce(t, s)
+		if calls := api.reset(); len(calls) > 0 {
+			t.Errorf("proxied %v: rerun made changes: %v", proxied, calls)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigsMerge(t *testing.T) {
+	base := writeConfig(t, `{
+		"hosts": {
+			"a": {"record": {"comment": "base", "tags": ["env:base", "team:x"], "priority": 5, "data": {"port": 80, "weight": 1}}},
+			"b": {"enabled": false}
+		},
+		"records": [{"type": "CNAME", "name": "www", "content": "a.example.com"}]
+	}`)
+	env := writeConfig(t, `{
+		"hosts": {
+			"a": {"record": {"tags": ["env:prod"], "proxied": true, "data": {"port": 443}}},
+			"c": {"record": {"comment": "new"}}
+		}
+	}`)
+	c, err := LoadConfigs([]string{base, env})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := c.HostOptions("a")
+	if a.Comment != "base" {
+		t.Errorf("comment = %q, want the base file's", a.Comment)
+	}
+	if !slices.Equal(a.Tags, []string{"env:prod"}) {
+		t.Errorf("tags = %v, want them replaced as a whole", a.Tags)
+	}
+	if a.Priority == nil || *a.Priority != 5 {
+		t.Errorf("priority = %v, want 5 from the base file", a.Priority)
+	}
+	if a.Proxied == nil || !*a.Proxied {
+		t.Errorf("proxied = %v, want true from the later file", a.Proxied)
+	}
+	if want := map[string]any{"port": float64(443), "weight": float64(1)}; !maps.Equal(a.Data, want) {
+		t.Errorf("data = %v, want %v merged by key", a.Data, want)
+	}
+	if c.HostEnabled("b") {
+		t.Error("b enabled, want the base file's enabled false kept")
+	}
+	if c.HostOptions("c").Comment != "new" {
+		t.Errorf("c = %+v, want it added by the later file", c.HostOptions("c"))
+	}
+	if len(c.Records) != 1 || c.Records[0].Name != "www" {
+		t.Errorf("records = %+v, want the base file's when the later one has none", c.Records)
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	path := writeConfig(t, `{"hosts": {"a": {"recrod": {}}}}`)
+	if _, err := LoadConfigs([]string{path}); err == nil {
+		t.Error("LoadConfigs accepted an unknown key")
+	}
+}
+
+func TestRunOnceHostDisabled(t *testing.T) {
+	api := newFakeDNS()
+	s := testSyncer(api, peer("a", "100.64.0.5", "fd7a:115c:a1e0::5"), peer("b", "100.64.0.6"))
+	runOnce(t, s)
+	if n := len(api.byName("a.wg.example.com")); n != 2 {
+		t.Fatalf("got %d records of a, want 2", n)
+	}
+
+	disabled := false
+	s.Config = &Config{Hosts: map[string]HostConfig{"a": {Enabled: &disabled}}}
+	runOnce(t, s)
+	if records := api.byName("a.wg.example.com"); len(records) > 0 {
+		t.Errorf("records of disabled a = %+v, want none", records)
+	}
+	if n := len(api.byName("b.wg.example.com")); n != 1 {
+		t.Errorf("got %d records of b, want it untouched", n)
+	}
+	api.reset()
+
+	enabled := true
+	s.Config.Hosts["a"] = HostConfig{Enabled: &enabled}
+	runOnce(t, s)
+	if n := len(api.byName("a.wg.example.com")); n != 2 {
+		t.Errorf("got %d records of a after enabling it again, want 2", n)
+	}
+	if n := api.count("delete"); n > 0 {
+		t.Errorf("enabling a deleted %d records", n)
+	}
+}
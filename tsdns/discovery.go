@@ -0,0 +1,44 @@
+package tsdns
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxTXTString is the length limit of one character string in a TXT record.
+const maxTXTString = 255
+
+// hostsTXT returns the HostsTXT record listing the labels of hosts, or false
+// if there are none.
+func (s *Syncer) hostsTXT(hosts []Host) (Host, bool) {
+	var labels []string
+	for _, h := range hosts {
+		if h.Name != "*" && s.Config.HostEnabled(h.Name) && !slices.Contains(labels, h.Label()) {
+			labels = append(labels, h.Label())
+		}
+	}
+	if len(labels) == 0 {
+		return Host{}, false
+	}
+	slices.Sort(labels)
+	return Host{Name: s.HostsTXT, Type: "TXT", Target: txtContent(labels)}, true
+}
+
+// txtContent joins words with spaces into quoted character strings of up to
+// maxTXTString bytes each.
+func txtContent(words []string) string {
+	var parts []string
+	var cur string
+	for _, w := range words {
+		if len(cur) > 0 && len(cur)+1+len(w) > maxTXTString {
+			parts = append(parts, cur)
+			cur = ""
+		}
+		if len(cur) > 0 {
+			cur += " "
+		}
+		cur += w
+	}
+	parts = append(parts, cur)
+	return `"` + strings.Join(parts, `" "`) + `"`
+}
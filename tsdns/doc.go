@@ -0,0 +1,19 @@
+// Package tsdns publishes tailscale devices as cloudflare dns records. It is
+// the engine behind the cloudflare-tailscale-dns command.
+//
+// A Syncer reads devices from a NodeSource (LocalSource for the local
+// tailscaled, APISource for the tailscale http api), builds records under
+// each Zone's domains and reconciles them with cloudflare:
+//
+//	s := &tsdns.Syncer{
+//		API:    api,
+//		Source: tsdns.LocalSource{},
+//		Zones:  []tsdns.Zone{{ID: zoneID, Name: "example.com", Domains: domains}},
+//		Tag:    "tag:server",
+//		TTL:    tsdns.DefaultTTL,
+//	}
+//	err := s.RunOnce(ctx)
+//
+// Records written by a Syncer carry an owner marker in their comment, and
+// orphan removal only touches marked records.
+package tsdns
@@ -0,0 +1,344 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// DNSDomain describes where records are built: <host>.<Sub>.<Domain>, or
+// <host><Separator><Sub>.<Domain> when flattened.
+type DNSDomain struct {
+	Domain    string
+	Sub       string
+	Tag       string
+	Flatten   bool
+	Separator string
+	// Zone is the cloudflare zone holding the records, if the subdomain is
+	// delegated to its own zone. Empty means Domain.
+	Zone string
+	// Strict requires a label boundary in front of the suffix when
+	// matching record names.
+	Strict bool
+	// External publishes only the hosts of Syncer.ExternalOverrides, with
+	// their external content.
+	External bool
+}
+
+// BuildHostname returns the record name for host.
+func (d DNSDomain) BuildHostname(host string) string {
+	if d.Flatten && len(d.Sub) > 0 {
+		return strings.ToLower(host+d.Separator+d.Sub) + "." + strings.ToLower(d.Domain)
+	}
+	return strings.ToLower(host) + "." + d.String()
+}
+
+// MatchSuffix returns the name suffix shared by all records built for this
+// domain. In flatten mode the subdomain is part of the first label, so the
+// separator is included.
+func (d DNSDomain) MatchSuffix() string {
+	if d.Flatten && len(d.Sub) > 0 {
+		return strings.ToLower(d.Separator + d.Sub + "." + d.Domain)
+	}
+	return d.String()
+}
+
+// NormalizeZone turns a -zone value such as "https://Example.com/" or
+// "example.com." into a bare lower case domain, and rejects values that are
+// not a domain of at least two labels.
+func NormalizeZone(zone string) (string, error) {
+	z := strings.TrimSpace(zone)
+	if _, rest, ok := strings.Cut(z, "://"); ok {
+		z = rest
+	}
+	z, _, _ = strings.Cut(z, "/")
+	if host, port, ok := strings.Cut(z, ":"); ok && len(port) > 0 && strings.Trim(port, "0123456789") == "" {
+		z = host
+	}
+	z = strings.ToLower(strings.TrimSuffix(z, "."))
+	if !validHostname(z) || !strings.Contains(z, ".") {
+		return "", fmt.Errorf("zone %q is not a domain such as example.com", zone)
+	}
+	return z, nil
+}
+
+// ZoneName returns the name of the cloudflare zone holding the records.
+func (d DNSDomain) ZoneName() string {
+	if len(d.Zone) > 0 {
+		return strings.ToLower(d.Zone)
+	}
+	return strings.ToLower(d.Domain)
+}
+
+// Matches reports whether the record name is under this domain.
+func (d DNSDomain) Matches(name string) bool {
+	name = strings.ToLower(name)
+	suffix := d.MatchSuffix()
+	if !d.Strict {
+		return strings.HasSuffix(name, suffix)
+	}
+	if d.Flatten && len(d.Sub) > 0 {
+		host, ok := strings.CutSuffix(name, suffix)
+		return ok && len(host) > 0 && !strings.Contains(host, ".")
+	}
+	return name == suffix || strings.HasSuffix(name, "."+suffix)
+}
+
+// String returns the domain the records are nested under.
+func (d DNSDomain) String() string {
+	suffix := d.Domain
+	if len(d.Sub) > 0 {
+		suffix = d.Sub + "." + d.Domain
+	}
+	return strings.ToLower(suffix)
+}
+
+// DomainList is a set of domains synced together.
+type DomainList []DNSDomain
+
+// Matches reports whether name is under any of the domains.
+func (l DomainList) Matches(name string) bool {
+	for _, d := range l {
+		if d.Matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Host is a single record to publish: a host name and its address.
+type Host struct {
+	Name string
+	IP   netip.Addr
+	// Type and Target are set for hosts overridden with a cname instead of an
+	// ip, and for the HostsTXT record.
+	Type   string
+	Target string
+	// Segment is an extra label between the host name and the domain, set
+	// from the host's tag with Syncer.TagSubdomains.
+	Segment string
+	// Proxied is set for hosts carrying Syncer.ProxiedTag.
+	Proxied bool
+	// Legacy is the name the host had under Syncer.MigrateFrom, if it
+	// differs from Name.
+	Legacy string
+}
+
+// Label returns the host part of the record name, including the segment.
+func (t Host) Label() string {
+	if len(t.Segment) > 0 {
+		return t.Name + "." + t.Segment
+	}
+	return t.Name
+}
+
+// RecordType returns the dns record type for the host.
+func (t Host) RecordType() string {
+	if len(t.Type) > 0 {
+		return t.Type
+	}
+	if t.IP.Is6() {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// MaxPerPage is the largest page size Cloudflare accepts when listing dns
+// records.
+const MaxPerPage = 5000
+
+// Cloudflare accepts 1 for automatic, otherwise a ttl between MinTTL (only on
+// enterprise zones, 60 elsewhere) and MaxTTL seconds.
+const (
+	MinTTL = 30
+	MaxTTL = 86400
+	// DefaultTTL is the suggested ttl for unproxied records.
+	// Proxied records always use automatic.
+	DefaultTTL = 300
+)
+
+// ValidateTTL reports whether cloudflare accepts ttl.
+func ValidateTTL(ttl int) error {
+	if ttl == 1 || (ttl >= MinTTL && ttl <= MaxTTL) {
+		return nil
+	}
+	return fmt.Errorf("ttl must be 1 (automatic) or between %d and %d seconds, got %d", MinTTL, MaxTTL, ttl)
+}
+
+// ListDNSRecords fetches every record in the zone, perPage records at a time.
+func ListDNSRecords(ctx context.Context, api DNSClient, zoneID string, perPage int) ([]cloudflare.DNSRecord, error) {
+	params := cloudflare.ListDNSRecordsParams{
+		ResultInfo: cloudflare.ResultInfo{Page: 1, PerPage: perPage},
+	}
+	var records []cloudflare.DNSRecord
+	for {
+		page, info, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, page...)
+		if !info.HasMorePages() {
+			return records, nil
+		}
+		params.Page = info.Page + 1
+	}
+}
+
+// ListDNSRecordsUnder fetches the records whose name ends with one of
+// suffixes, filtered by cloudflare's name.endswith. The filter compares plain
+// strings, not labels, so callers still match the names themselves; a record
+// under several suffixes is returned once.
+func ListDNSRecordsUnder(ctx context.Context, api DNSClient, zoneID string, perPage int, suffixes []string) ([]cloudflare.DNSRecord, error) {
+	var records []cloudflare.DNSRecord
+	seen := make(map[string]struct{})
+	for _, suffix := range suffixes {
+		for page := 1; ; page++ {
+			query := url.Values{
+				"name.endswith": {suffix},
+				"page":          {strconv.Itoa(page)},
+				"per_page":      {strconv.Itoa(perPage)},
+			}
+			raw, err := api.Raw(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			var listed []cloudflare.DNSRecord
+			if err := json.Unmarshal(raw.Result, &listed); err != nil {
+				return nil, fmt.Errorf("invalid record list: %w", err)
+			}
+			for _, r := range listed {
+				if _, ok := seen[r.ID]; !ok {
+					seen[r.ID] = struct{}{}
+					records = append(records, r)
+				}
+			}
+			if raw.ResultInfo == nil || !raw.ResultInfo.HasMorePages() {
+				break
+			}
+		}
+	}
+	return records, nil
+}
+
+// ListZoneRecords fetches the records of z under its domains, or every
+// record when a domain is the zone itself.
+func ListZoneRecords(ctx context.Context, api DNSClient, z Zone, perPage int) ([]cloudflare.DNSRecord, error) {
+	suffixes := z.nameSuffixes()
+	if suffixes == nil {
+		return ListDNSRecords(ctx, api, z.ID, perPage)
+	}
+	return ListDNSRecordsUnder(ctx, api, z.ID, perPage, suffixes)
+}
+
+// nameSuffixes returns the name suffixes of z's domains, or nil if records
+// are built directly under the zone.
+func (z Zone) nameSuffixes() []string {
+	var suffixes []string
+	for _, d := range z.Domains {
+		if len(d.Sub) == 0 {
+			return nil
+		}
+		if !slices.Contains(suffixes, d.MatchSuffix()) {
+			suffixes = append(suffixes, d.MatchSuffix())
+		}
+	}
+	return suffixes
+}
+
+// hasAnySuffix reports whether name ends with one of suffixes, ignoring case.
+func hasAnySuffix(name string, suffixes []string) bool {
+	name = strings.ToLower(name)
+	return slices.ContainsFunc(suffixes, func(suffix string) bool {
+		return strings.HasSuffix(name, suffix)
+	})
+}
+
+// recordUpToDate reports whether r already has t's content and the given ttl
+// and proxied setting. Address records whose content is not an ip are
+// reported and never up to date.
+func recordUpToDate(r cloudflare.DNSRecord, t Host, ttl int, proxied bool) bool {
+	if len(t.Target) == 0 {
+		if _, err := netip.ParseAddr(r.Content); err != nil {
+			log.Printf("warning: dns record %s %s has invalid content %q: %v", r.Type, r.Name, r.Content, err)
+			return false
+		}
+	}
+	return sameContent(r, t) && r.TTL == ttl && (r.Proxied != nil && *r.Proxied) == proxied
+}
+
+// sameContent reports whether r points where t wants, its ip or cname target.
+func sameContent(r cloudflare.DNSRecord, t Host) bool {
+	if len(t.Target) > 0 {
+		return strings.EqualFold(strings.TrimSuffix(r.Content, "."), t.Target)
+	}
+	current, err := netip.ParseAddr(r.Content)
+	return err == nil && current == t.IP
+}
+
+// PrintRecords writes a table of the records under any of domains.
+func PrintRecords(out io.Writer, records []cloudflare.DNSRecord, domains DomainList) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tCONTENT\tTTL\tPROXIED\tCOMMENT")
+	for _, r := range records {
+		if !domains.Matches(r.Name) {
+			continue
+		}
+		proxied := r.Proxied != nil && *r.Proxied
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%t\t%s\n", r.Type, r.Name, r.Content, r.TTL, proxied, r.Comment)
+	}
+	w.Flush()
+}
+
+// matchesOS reports whether peerOS is one of oses. An empty list matches any
+// os.
+func matchesOS(peerOS string, oses []string) bool {
+	if len(oses) == 0 {
+		return true
+	}
+	for _, o := range oses {
+		if strings.EqualFold(o, peerOS) {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeHost replaces every character of name that is not a letter,
+// digit, '-', '_' or '.' with replacement, e.g. spaces and non ascii letters.
+func SanitizeHost(name, replacement string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if nameChar(c) || c == '.' {
+			b.WriteRune(c)
+		} else {
+			b.WriteString(replacement)
+		}
+	}
+	return b.String()
+}
+
+// ValidReplacement reports whether r is safe to put in a dns label, so
+// SanitizeHost does not produce names cloudflare rejects.
+func ValidReplacement(r string) bool {
+	for _, c := range r {
+		if !nameChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func nameChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
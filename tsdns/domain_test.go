@@ -0,0 +1,88 @@
+package tsdns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestDomainMatchesStrict(t *testing.T) {
+	apex := DNSDomain{Domain: "example.com", Strict: true}
+	sub := DNSDomain{Domain: "example.com", Sub: "wg", Strict: true}
+	flat := DNSDomain{Domain: "example.com", Sub: "wg", Flatten: true, Separator: "-", Strict: true}
+	loose := DNSDomain{Domain: "example.com"}
+	tests := []struct {
+		domain DNSDomain
+		name   string
+		want   bool
+	}{
+		{apex, "a.example.com", true},
+		{apex, "example.com", true},
+		{apex, "A.Example.COM", true},
+		{apex, "notexample.com", false},
+		{apex, "a.notexample.com", false},
+		{sub, "a.wg.example.com", true},
+		{sub, "a.notwg.example.com", false},
+		{sub, "a.example.com", false},
+		{flat, "a-wg.example.com", true},
+		{flat, "b.a-wg.example.com", false},
+		{loose, "notexample.com", true},
+	}
+	for _, tt := range tests {
+		if got := tt.domain.Matches(tt.name); got != tt.want {
+			t.Errorf("%+v Matches(%q) = %v, want %v", tt.domain, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveAllStrictZone(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "ours", Type: "A", Name: "a.example.com", Content: "100.64.0.5", Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "theirs", Type: "A", Name: "notexample.com", Content: "100.64.0.6", Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "theirs-sub", Type: "A", Name: "a.notexample.com", Content: "100.64.0.7", Comment: ownerMarker},
+	)
+	s := testSyncer(api)
+	s.Zones = []Zone{{ID: "zone1", Name: "example.com", Domains: DomainList{{Domain: "example.com", Strict: true}}}}
+	if err := s.RemoveAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.reset(); len(calls) != 1 || calls[0] != "delete ours" {
+		t.Errorf("calls = %v, want only delete ours", calls)
+	}
+}
+
+func TestProtectedRecordsNeverRemoved(t *testing.T) {
+	records := []cloudflare.DNSRecord{
+		{ID: "ns", Type: "NS", Name: "example.com", Content: "ns1.cloudflare.com", Comment: ownerMarker},
+		{ID: "soa", Type: "SOA", Name: "example.com", Content: "ns1.cloudflare.com", Comment: ownerMarker},
+		{ID: "sub-ns", Type: "NS", Name: "wg.example.com", Content: "ns1.example.net", Comment: ownerMarker},
+		{ID: "apex", Type: "A", Name: "example.com", Content: "100.64.0.1", Comment: ownerMarker},
+		{ID: "apex6", Type: "AAAA", Name: "example.com", Content: "fd7a:115c:a1e0::1", Comment: ownerMarker},
+		{ID: "host", Type: "A", Name: "gone.example.com", Content: "100.64.0.2", Comment: ownerMarker},
+	}
+	zone := Zone{ID: "zone1", Name: "example.com", Domains: DomainList{{Domain: "example.com", Strict: true}}}
+	tests := []struct {
+		name string
+		run  func(s *Syncer) error
+	}{
+		{"remove all", func(s *Syncer) error { return s.RemoveAll(context.Background()) }},
+		{"remove orphans", func(s *Syncer) error {
+			s.RemoveOrphans = true
+			return s.RunOnce(context.Background())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNS(records...)
+			s := testSyncer(api)
+			s.Zones = []Zone{zone}
+			if err := tt.run(s); err != nil {
+				t.Fatal(err)
+			}
+			if calls := api.reset(); len(calls) != 1 || calls[0] != "delete host" {
+				t.Errorf("calls = %v, want only delete host", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package tsdns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Explain prints every node of the source and whether it gets records, or
+// why it is skipped. Limit is not taken into account.
+func (s *Syncer) Explain(ctx context.Context, out io.Writer) error {
+	nodes, err := s.Source.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(nodes, func(a, b Node) int {
+		return strings.Compare(strings.ToLower(a.HostName), strings.ToLower(b.HostName))
+	})
+	for _, n := range nodes {
+		reason := s.skipReason(n)
+		if len(reason) == 0 {
+			if ips, _ := s.addrs(n); len(ips) == 0 {
+				reason = "no addresses in the tailscale ranges"
+			} else if _, err := s.tagSegments(n); err != nil {
+				reason = err.Error()
+			}
+		}
+		name := n.HostName
+		if n.Self {
+			name += " (self)"
+		}
+		switch {
+		case len(reason) > 0:
+			fmt.Fprintf(out, "%s: skipped, %s\n", name, reason)
+		case n.Self && !s.SelfRespectsTag && !hasTag(n.Tags, s.Tag):
+			fmt.Fprintf(out, "%s: included, the local node is added regardless of -tag unless -self-respects-tag\n", name)
+		default:
+			fmt.Fprintf(out, "%s: included\n", name)
+		}
+	}
+	return nil
+}
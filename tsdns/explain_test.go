@@ -0,0 +1,44 @@
+package tsdns
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestUntaggedSelf(t *testing.T) {
+	self := peer("me", "100.64.0.1")
+	self.Self, self.Tags = true, nil
+	tests := []struct {
+		name        string
+		respectsTag bool
+		wantReason  string
+		wantExplain string
+	}{
+		{"added regardless of tag", false, "", "me (self): included, the local node is added regardless of -tag unless -self-respects-tag"},
+		{"self respects tag", true, "not tagged tag:dns", "me (self): skipped, not tagged tag:dns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSyncer(newFakeDNS(), self)
+			s.SelfRespectsTag = tt.respectsTag
+			if got := s.skipReason(self); got != tt.wantReason {
+				t.Errorf("skipReason = %q, want %q", got, tt.wantReason)
+			}
+			var out bytes.Buffer
+			if err := s.Explain(context.Background(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(out.String()); got != tt.wantExplain {
+				t.Errorf("Explain = %q, want %q", got, tt.wantExplain)
+			}
+			api := newFakeDNS()
+			s.API = api
+			runOnce(t, s)
+			if got, want := len(api.byName("me.wg.example.com")), len(tt.wantReason) == 0; (got == 1) != want {
+				t.Errorf("got %d records for the local node, want it added %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// autoTTL is the ttl cloudflare serves for records with the automatic ttl.
+const autoTTL = 300
+
+// Export writes the managed records of every zone to w, as json in the state
+// file format, with format "bind" as a zone file or with format "terraform"
+// as terraform import commands.
+func (s *Syncer) Export(ctx context.Context, w io.Writer, format string) error {
+	if format != "json" && format != "bind" && format != "terraform" {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	var state []stateRecord
+	var b, tf strings.Builder
+	resources := make(map[string]bool)
+	for _, z := range s.Zones {
+		records, err := ListZoneRecords(ctx, s.API, z, s.PerPage)
+		if err != nil {
+			return err
+		}
+		SortRecords(records)
+		fmt.Fprintf(&b, "; zone %s\n", z.Name)
+		for _, r := range records {
+			if !z.Domains.Matches(r.Name) || !owned(s.InstanceID, r) {
+				continue
+			}
+			state = append(state, newStateRecord(z.ID, r))
+			b.WriteString(bindRecord(r))
+			fmt.Fprintf(&tf, "terraform import cloudflare_record.%s %s/%s\n", resourceName(resources, z.Name, r), z.ID, r.ID)
+		}
+	}
+	switch format {
+	case "bind":
+		_, err := io.WriteString(w, b.String())
+		return err
+	case "terraform":
+		_, err := io.WriteString(w, tf.String())
+		return err
+	}
+	if state == nil {
+		state = make([]stateRecord, 0)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+// resourceName returns a terraform resource name for r that is not used
+// yet, built from its name within zone and its type, e.g. nas_wg_a.
+func resourceName(used map[string]bool, zone string, r cloudflare.DNSRecord) string {
+	name := strings.TrimSuffix(strings.ToLower(r.Name), "."+zone)
+	name = strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, name) + "_" + strings.ToLower(r.Type)
+	if c := name[0]; c == '-' || c >= '0' && c <= '9' {
+		name = "_" + name
+	}
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + "_" + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// bindRecord formats r as a zone file line with a fully qualified name.
+// Automatic ttls are written as the ttl cloudflare serves for them.
+func bindRecord(r cloudflare.DNSRecord) string {
+	ttl := r.TTL
+	if ttl == 1 {
+		ttl = autoTTL
+	}
+	content := r.Content
+	switch r.Type {
+	case "CNAME":
+		content = strings.TrimSuffix(content, ".") + "."
+	case "TXT":
+		if !strings.HasPrefix(content, `"`) {
+			content = strconv.Quote(content)
+		}
+	}
+	return fmt.Sprintf("%s.\t%d\tIN\t%s\t%s\n", r.Name, ttl, r.Type, content)
+}
@@ -0,0 +1,195 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// fakeDNS is an in-memory DNSClient for a single zone. It records every call
+// that changes a record, in order, e.g. "create A a.wg.example.com 100.64.0.5"
+// or "delete r1".
+type fakeDNS struct {
+	mu      sync.Mutex
+	records map[string]cloudflare.DNSRecord
+	nextID  int
+	calls   []string
+	// fail makes changes to a record fail, keyed by the record name.
+	fail map[string]error
+	// gone holds ids that are still listed but were removed since, so
+	// updates and deletes of them are not found.
+	gone map[string]bool
+}
+
+func newFakeDNS(records ...cloudflare.DNSRecord) *fakeDNS {
+	f := &fakeDNS{records: make(map[string]cloudflare.DNSRecord), fail: make(map[string]error), gone: make(map[string]bool)}
+	for _, r := range records {
+		if len(r.ID) == 0 {
+			r.ID = f.newID()
+		}
+		f.records[r.ID] = r
+	}
+	return f
+}
+
+func (f *fakeDNS) newID() string {
+	f.nextID++
+	return fmt.Sprintf("r%d", f.nextID)
+}
+
+// count returns how many calls start with prefix, e.g. "create".
+func (f *fakeDNS) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// reset returns the calls made so far and forgets them.
+func (f *fakeDNS) reset() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// list returns the records, sorted by name, type and content.
+func (f *fakeDNS) list() []cloudflare.DNSRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := make([]cloudflare.DNSRecord, 0, len(f.records))
+	for _, r := range f.records {
+		records = append(records, r)
+	}
+	SortRecords(records)
+	return records
+}
+
+// byName returns the records named name.
+func (f *fakeDNS) byName(name string) []cloudflare.DNSRecord {
+	return slices.DeleteFunc(f.list(), func(r cloudflare.DNSRecord) bool {
+		return r.Name != name
+	})
+}
+
+func notFound() error {
+	err := cloudflare.NewNotFoundError(&cloudflare.Error{StatusCode: http.StatusNotFound, ErrorMessages: []string{"Record does not exist."}})
+	return &err
+}
+
+func (f *fakeDNS) ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	records := f.list()
+	return records, &cloudflare.ResultInfo{Page: 1, PerPage: len(records), TotalPages: 1, Count: len(records), Total: len(records)}, nil
+}
+
+func (f *fakeDNS) GetDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) (cloudflare.DNSRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := f.records[recordID]
+	if !ok || f.gone[recordID] {
+		return cloudflare.DNSRecord{}, notFound()
+	}
+	return r, nil
+}
+
+func (f *fakeDNS) CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("create %s %s %s", params.Type, params.Name, params.Content))
+	if err := f.fail[params.Name]; err != nil {
+		return cloudflare.DNSRecord{}, err
+	}
+	for id, r := range f.records {
+		if !f.gone[id] && r.Type == params.Type && r.Name == params.Name && r.Content == params.Content {
+			return cloudflare.DNSRecord{}, fmt.Errorf("an identical record already exists")
+		}
+	}
+	proxied := params.Proxied != nil && *params.Proxied
+	ttl := params.TTL
+	if ttl == 0 {
+		ttl = 1
+	}
+	r := cloudflare.DNSRecord{
+		ID:       f.newID(),
+		Type:     params.Type,
+		Name:     params.Name,
+		Content:  params.Content,
+		TTL:      ttl,
+		Proxied:  &proxied,
+		Comment:  params.Comment,
+		Tags:     params.Tags,
+		Priority: params.Priority,
+		Settings: params.Settings,
+		Data:     params.Data,
+	}
+	f.records[r.ID] = r
+	return r, nil
+}
+
+func (f *fakeDNS) UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "update "+params.ID)
+	r, ok := f.records[params.ID]
+	if !ok || f.gone[params.ID] {
+		return cloudflare.DNSRecord{}, notFound()
+	}
+	if err := f.fail[r.Name]; err != nil {
+		return cloudflare.DNSRecord{}, err
+	}
+	r.Type, r.Name, r.Content, r.TTL = params.Type, params.Name, params.Content, params.TTL
+	if params.Proxied != nil {
+		r.Proxied = params.Proxied
+	}
+	if params.Comment != nil {
+		r.Comment = *params.Comment
+	}
+	r.Tags, r.Priority, r.Settings, r.Data = params.Tags, params.Priority, params.Settings, params.Data
+	f.records[r.ID] = r
+	return r, nil
+}
+
+func (f *fakeDNS) DeleteDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, recordID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "delete "+recordID)
+	r, ok := f.records[recordID]
+	if !ok || f.gone[recordID] {
+		return notFound()
+	}
+	if err := f.fail[r.Name]; err != nil {
+		return err
+	}
+	delete(f.records, recordID)
+	return nil
+}
+
+// Raw answers the name suffix filtered listing of ListDNSRecordsUnder.
+func (f *fakeDNS) Raw(ctx context.Context, method, endpoint string, data interface{}, headers http.Header) (cloudflare.RawResponse, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || method != http.MethodGet || !strings.HasSuffix(u.Path, "/dns_records") {
+		return cloudflare.RawResponse{}, fmt.Errorf("fake: unsupported request %s %s", method, endpoint)
+	}
+	suffix := u.Query().Get("name.endswith")
+	records := slices.DeleteFunc(f.list(), func(r cloudflare.DNSRecord) bool {
+		return !strings.HasSuffix(r.Name, suffix)
+	})
+	b, err := json.Marshal(records)
+	if err != nil {
+		return cloudflare.RawResponse{}, err
+	}
+	return cloudflare.RawResponse{Result: b}, nil
+}
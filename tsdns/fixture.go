@@ -0,0 +1,49 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// LoadRecords reads a recorded record list, either a json array of records
+// or a cloudflare api response with the records in "result".
+func LoadRecords(path string) ([]cloudflare.DNSRecord, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []cloudflare.DNSRecord
+	if err := json.Unmarshal(b, &records); err == nil {
+		return records, nil
+	}
+	var resp cloudflare.DNSListResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return resp.Result, nil
+}
+
+// listRecords lists the zone's records from Fixture if set, otherwise from
+// cloudflare, only the ones under the zone's domains unless all is set.
+// Fixture records belong to the zone their name is in.
+func (s *Syncer) listRecords(ctx context.Context, z Zone, all bool) ([]cloudflare.DNSRecord, error) {
+	if s.Fixture == nil && all {
+		return ListDNSRecords(ctx, s.API, z.ID, s.PerPage)
+	}
+	if s.Fixture == nil {
+		return ListZoneRecords(ctx, s.API, z, s.PerPage)
+	}
+	var records []cloudflare.DNSRecord
+	for _, r := range s.Fixture {
+		name, zone := strings.ToLower(r.Name), strings.ToLower(z.Name)
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,105 @@
+package tsdns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+// peerRuns counts the consecutive passes a peer was online or offline.
+type peerRuns struct {
+	Online    int  `json:"online_runs"`
+	Offline   int  `json:"offline_runs"`
+	Published bool `json:"published"`
+}
+
+// hysteresis reports whether OnlineThreshold or OfflineThreshold delay
+// changes of a peer's online state.
+func (s *Syncer) hysteresis() bool {
+	return s.OnlineThreshold > 1 || s.OfflineThreshold > 1
+}
+
+// settleOnline counts this pass's online state of every peer and returns
+// whether each peer counts as online: a peer is published once it was online
+// for OnlineThreshold passes and unpublished once it was offline for
+// OfflineThreshold passes. Without history, e.g. on the first pass, peers
+// count as they are. stale is set when PeerStateFile was too old to use, so
+// the caller can keep records of peers that only look offline.
+func (s *Syncer) settleOnline(nodes []Node) (settled map[string]bool, stale bool, err error) {
+	if s.peers == nil {
+		var age time.Duration
+		if age, stale, err = s.staleState(s.PeerStateFile); err != nil {
+			return nil, false, err
+		}
+		peers := make(map[string]peerRuns)
+		if stale {
+			log.Printf("peer state %s was written %s ago, ignoring it", s.PeerStateFile, age.Round(time.Second))
+		} else if peers, err = readPeerState(s.PeerStateFile); err != nil {
+			return nil, false, err
+		}
+		s.peers = peers
+	}
+	first := len(s.peers) == 0
+	runs := make(map[string]peerRuns, len(nodes))
+	settled = make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		if n.Self {
+			continue
+		}
+		r, known := s.peers[n.HostName]
+		if n.Online || s.recentlySeen(n) {
+			r.Online, r.Offline = r.Online+1, 0
+		} else {
+			r.Online, r.Offline = 0, r.Offline+1
+		}
+		switch {
+		case first || !known && r.Offline > 0:
+			r.Published = r.Online > 0
+		case !r.Published && r.Online >= s.OnlineThreshold:
+			r.Published = true
+		case r.Published && r.Offline >= s.OfflineThreshold:
+			r.Published = false
+		}
+		runs[n.HostName] = r
+		settled[n.HostName] = r.Published
+	}
+	s.peers = runs
+	if len(s.PeerStateFile) > 0 && !s.DryRun {
+		if err := writePeerState(s.PeerStateFile, runs); err != nil {
+			return nil, false, fmt.Errorf("unable to write peer state %s: %w", s.PeerStateFile, err)
+		}
+	}
+	return settled, stale, nil
+}
+
+// readPeerState reads a file written by writePeerState. A missing file or
+// an empty path is an empty state.
+func readPeerState(path string) (map[string]peerRuns, error) {
+	peers := make(map[string]peerRuns)
+	if len(path) == 0 {
+		return peers, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return peers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &peers); err != nil {
+		return nil, fmt.Errorf("invalid peer state file %s: %w", path, err)
+	}
+	return peers, nil
+}
+
+func writePeerState(path string, peers map[string]peerRuns) error {
+	b, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
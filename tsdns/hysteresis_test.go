@@ -0,0 +1,40 @@
+package tsdns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestRunOnceStalePeerStateKeepsRecords(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.5", TTL: DefaultTTL, Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "b", Type: "A", Name: "b.wg.example.com", Content: "100.64.0.6", TTL: DefaultTTL, Comment: ownerMarker},
+	)
+	peerState := filepath.Join(t.TempDir(), "peers.json")
+	if err := os.WriteFile(peerState, []byte(`{"a": {"online_runs": 3, "published": true}, "b": {"online_runs": 3, "published": true}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(peerState, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	offline := peer("b", "100.64.0.6")
+	offline.Online = false
+	s := testSyncer(api, peer("a", "100.64.0.5"), offline)
+	s.PeerStateFile = peerState
+	s.StateMaxAge = time.Hour
+	s.OfflineThreshold = 2
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	if calls := api.reset(); len(calls) > 0 {
+		t.Errorf("calls = %v, want the records of offline peers kept", calls)
+	}
+	if records := api.byName("b.wg.example.com"); len(records) != 1 {
+		t.Errorf("records of b = %+v, want it kept", records)
+	}
+}
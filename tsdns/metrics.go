@@ -0,0 +1,101 @@
+package tsdns
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Metrics accumulates the results of sync passes and serves them in the
+// OpenMetrics text format. Labels are limited to zone and action, never
+// record names, to keep the number of series bounded.
+type Metrics struct {
+	mu           sync.Mutex
+	changes      map[metricKey]int
+	failures     map[metricKey]int
+	managed      map[string]int
+	syncs        int
+	syncFailures int
+}
+
+type metricKey struct {
+	zone, action string
+}
+
+// NewMetrics returns empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		changes:  make(map[metricKey]int),
+		failures: make(map[metricKey]int),
+		managed:  make(map[string]int),
+	}
+}
+
+// observe adds the result of a pass. The managed records gauge is only
+// updated for zones that synced.
+func (m *Metrics) observe(zones []Zone, zoneState [][]stateRecord, rep *runReport, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncs++
+	if err != nil {
+		m.syncFailures++
+	}
+	rep.mu.Lock()
+	for _, a := range rep.Actions {
+		k := metricKey{zone: a.Zone, action: a.Action}
+		switch {
+		case len(a.Error) > 0:
+			m.failures[k]++
+		case a.Action != "unchanged" && a.Action != "refused":
+			m.changes[k]++
+		}
+	}
+	rep.mu.Unlock()
+	for i, records := range zoneState {
+		if records != nil {
+			m.managed[zones[i].Name] = len(records)
+		}
+	}
+}
+
+// ServeHTTP writes the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	writeCounters(w, "tsdns_record_changes", "Records changed, by zone and action.", m.changes)
+	writeCounters(w, "tsdns_record_failures", "Record changes that failed, by zone and action.", m.failures)
+	fmt.Fprintln(w, "# TYPE tsdns_managed_records gauge")
+	fmt.Fprintln(w, "# HELP tsdns_managed_records Records managed after the last sync of the zone.")
+	zones := make([]string, 0, len(m.managed))
+	for z := range m.managed {
+		zones = append(zones, z)
+	}
+	slices.Sort(zones)
+	for _, z := range zones {
+		fmt.Fprintf(w, "tsdns_managed_records{zone=%s} %d\n", strconv.Quote(z), m.managed[z])
+	}
+	fmt.Fprintln(w, "# TYPE tsdns_syncs counter")
+	fmt.Fprintf(w, "tsdns_syncs_total %d\n", m.syncs)
+	fmt.Fprintln(w, "# TYPE tsdns_sync_failures counter")
+	fmt.Fprintf(w, "tsdns_sync_failures_total %d\n", m.syncFailures)
+	fmt.Fprintln(w, "# EOF")
+}
+
+func writeCounters(w http.ResponseWriter, name, help string, values map[metricKey]int) {
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	keys := make([]metricKey, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b metricKey) int {
+		return cmp.Or(cmp.Compare(a.zone, b.zone), cmp.Compare(a.action, b.action))
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s_total{zone=%s,action=%s} %d\n", name, strconv.Quote(k.zone), strconv.Quote(k.action), values[k])
+	}
+}
@@ -0,0 +1,71 @@
+package tsdns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"strings"
+	"text/template"
+)
+
+var errEmptyName = errors.New("name template rendered an empty name")
+
+// nameData is the data available to -name-template.
+type nameData struct {
+	Host string
+}
+
+var nameFuncs = template.FuncMap{
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"lower":      strings.ToLower,
+	"short": func(s string) string {
+		label, _, _ := strings.Cut(s, ".")
+		return label
+	},
+	"hash": func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:4])
+	},
+}
+
+// IPName returns prefix followed by ip as a record label, the dotted or
+// expanded ipv6 form with '-' for the separators, e.g. host-100-64-0-5 or
+// host-fd7a-115c-a1e0-0000-0000-0000-0000-0005.
+func IPName(prefix string, ip netip.Addr) string {
+	s := ip.WithZone("").StringExpanded()
+	if ip.Is4() || ip.Is4In6() {
+		s = ip.Unmap().String()
+	}
+	return prefix + strings.NewReplacer(".", "-", ":", "-").Replace(s)
+}
+
+// ParseNameTemplate parses text and checks that it renders a non empty name
+// for a sample host.
+func ParseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Funcs(nameFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := renderName(tmpl, "sample-host"); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func renderName(tmpl *template.Template, host string) (string, error) {
+	if tmpl == nil {
+		return host, nil
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nameData{Host: host}); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(b.String())
+	if len(name) == 0 {
+		return "", errEmptyName
+	}
+	return name, nil
+}
@@ -0,0 +1,146 @@
+package tsdns
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ParseOverride parses an -override value of the form host=[type:]content.
+// type is a, aaaa or cname, in any case. Without a type, content must be an
+// ip and the type follows from it. The type is looked for first, as values
+// like aaaa:fd7a::5 are ipv6 literals too; only a type followed by "::", as
+// in aaaa::1, is read as part of the address.
+func ParseOverride(s string) (Host, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || len(name) == 0 || len(value) == 0 {
+		return Host{}, fmt.Errorf("invalid override %q, expected host=[type:]content", s)
+	}
+	h := Host{Name: name}
+
+	recordType := ""
+	content := value
+	if t, c, ok := strings.Cut(value, ":"); ok && !strings.HasPrefix(c, ":") {
+		switch strings.ToUpper(t) {
+		case "A", "AAAA", "CNAME":
+			recordType = strings.ToUpper(t)
+			content = c
+		default:
+			if _, err := netip.ParseAddr(value); err != nil {
+				recordType = strings.ToUpper(t)
+				content = c
+			}
+		}
+	}
+
+	switch recordType {
+	case "":
+		ip, err := netip.ParseAddr(content)
+		if err != nil {
+			return Host{}, fmt.Errorf("invalid override %q: content is not an ip, use cname:<target> for hostnames", s)
+		}
+		h.IP = ip
+	case "A", "AAAA":
+		ip, err := netip.ParseAddr(content)
+		if err != nil {
+			return Host{}, fmt.Errorf("invalid override %q: %v", s, err)
+		}
+		h.IP = ip
+		if h.RecordType() != recordType {
+			return Host{}, fmt.Errorf("invalid override %q: %s is not a valid %s address", s, ip, recordType)
+		}
+	case "CNAME":
+		target := strings.ToLower(strings.TrimSuffix(content, "."))
+		if _, err := netip.ParseAddr(target); err == nil {
+			return Host{}, fmt.Errorf("invalid override %q: cname target must be a hostname, not an ip", s)
+		}
+		if !validHostname(target) {
+			return Host{}, fmt.Errorf("invalid override %q: %q is not a valid hostname", s, target)
+		}
+		h.Type = "CNAME"
+		h.Target = target
+	default:
+		return Host{}, fmt.Errorf("invalid override %q: unknown record type %q", s, recordType)
+	}
+	return h, nil
+}
+
+// LoadOverrides reads overrides from a file with one host=[type:]content per
+// line, as for ParseOverride. Empty lines and lines starting with # are
+// ignored.
+func LoadOverrides(path string) ([]Host, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []Host
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := ParseOverride(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// validHostname reports whether name is a dot separated list of labels made
+// of letters, digits, hyphens and underscores.
+func validHostname(name string) bool {
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// externalHosts returns the hosts of an External domain: the overridden hosts
+// with their external content. The others are not published there.
+func externalHosts(hosts []Host, overrides map[string][]Host) []Host {
+	kept := slices.DeleteFunc(slices.Clone(hosts), func(h Host) bool {
+		_, ok := overrides[h.Name]
+		return !ok
+	})
+	return applyOverrides(kept, overrides)
+}
+
+// applyOverrides replaces the entries of every overridden host with its
+// overrides, keeping the host's segment.
+func applyOverrides(hosts []Host, overrides map[string][]Host) []Host {
+	if len(overrides) == 0 {
+		return hosts
+	}
+	out := make([]Host, 0, len(hosts))
+	applied := make(map[string]bool)
+	for _, h := range hosts {
+		o, ok := overrides[h.Name]
+		if !ok {
+			out = append(out, h)
+			continue
+		}
+		if !applied[h.Label()] {
+			applied[h.Label()] = true
+			for _, oh := range o {
+				oh.Segment = h.Segment
+				oh.Proxied = h.Proxied
+				out = append(out, oh)
+			}
+		}
+	}
+	return out
+}
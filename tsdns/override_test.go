@@ -0,0 +1,45 @@
+package tsdns
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Host
+		wantErr bool
+	}{
+		{in: "h=100.64.0.5", want: Host{Name: "h", IP: netip.MustParseAddr("100.64.0.5")}},
+		{in: "h=fd7a:115c:a1e0::5", want: Host{Name: "h", IP: netip.MustParseAddr("fd7a:115c:a1e0::5")}},
+		{in: "h=a:100.64.0.5", want: Host{Name: "h", IP: netip.MustParseAddr("100.64.0.5")}},
+		{in: "h=A:100.64.0.5", want: Host{Name: "h", IP: netip.MustParseAddr("100.64.0.5")}},
+		// Both are ipv6 literals themselves; the prefix wins.
+		{in: "h=aaaa:fd7a:115c:a1e0::5", want: Host{Name: "h", IP: netip.MustParseAddr("fd7a:115c:a1e0::5")}},
+		{in: "h=AAAA:fd7a:115c:a1e0::5", want: Host{Name: "h", IP: netip.MustParseAddr("fd7a:115c:a1e0::5")}},
+		{in: "h=a:fd7a::1", wantErr: true},
+		{in: "h=aaaa:100.64.0.5", wantErr: true},
+		// A type followed by "::" is part of the address.
+		{in: "h=aaaa::1", want: Host{Name: "h", IP: netip.MustParseAddr("aaaa::1")}},
+		{in: "h=cname:Target.Example.com.", want: Host{Name: "h", Type: "CNAME", Target: "target.example.com"}},
+		{in: "h=CNAME:target.example.com", want: Host{Name: "h", Type: "CNAME", Target: "target.example.com"}},
+		{in: "h=cname:100.64.0.5", wantErr: true},
+		{in: "h=target.example.com", wantErr: true},
+		{in: "h=mx:mail.example.com", wantErr: true},
+		{in: "h=", wantErr: true},
+		{in: "=100.64.0.5", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOverride(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseOverride(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseOverride(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
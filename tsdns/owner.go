@@ -0,0 +1,57 @@
+package tsdns
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// ownerMarker starts the comment of every record this program manages.
+// Records under the managed domains without it are left alone unless adopted.
+const ownerMarker = "managed by cloudflare-tailscale-dns"
+
+// ownerComment returns the record comment for a record managed by instance,
+// keeping the configured comment after the marker.
+func ownerComment(instance, comment string) string {
+	marker := instanceMarker(instance)
+	if len(comment) == 0 {
+		return marker
+	}
+	return marker + "; " + comment
+}
+
+// instanceMarker returns the marker of instance. The default instance uses
+// the bare marker.
+func instanceMarker(instance string) string {
+	if len(instance) == 0 {
+		return ownerMarker
+	}
+	return ownerMarker + " instance=" + instance
+}
+
+// owned reports whether r carries instance's marker. Records of other
+// instances are not owned.
+func owned(instance string, r cloudflare.DNSRecord) bool {
+	marker := instanceMarker(instance)
+	return r.Comment == marker || strings.HasPrefix(r.Comment, marker+"; ")
+}
+
+// ValidInstanceID reports whether id can be encoded in the owner marker.
+func ValidInstanceID(id string) bool {
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// adoptable reports whether an unmanaged record matches the -adopt pattern by
+// name or comment. Records with any instance's marker are managed already.
+func adoptable(adopt *regexp.Regexp, r cloudflare.DNSRecord) bool {
+	if adopt == nil || strings.HasPrefix(r.Comment, ownerMarker) {
+		return false
+	}
+	return adopt.MatchString(r.Name) || adopt.MatchString(r.Comment)
+}
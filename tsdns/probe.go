@@ -0,0 +1,70 @@
+package tsdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Probe checks that the tailscale source answers, the cloudflare token is
+// valid and active, and every zone can be found and written. It prints one OK or FAIL
+// line per check and reports whether all of them passed. Nothing is changed.
+func Probe(ctx context.Context, out io.Writer, source NodeSource, api *cloudflare.API, zoneNames []string) bool {
+	ok := true
+	check := func(name string, err error) {
+		if err != nil {
+			ok = false
+			fmt.Fprintf(out, "FAIL %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(out, "OK   %s\n", name)
+	}
+
+	nodes, err := source.Nodes(ctx)
+	if err == nil {
+		check(fmt.Sprintf("tailscale (%d nodes)", len(nodes)), nil)
+	} else {
+		check("tailscale", err)
+	}
+
+	token, err := api.VerifyAPIToken(ctx)
+	if err == nil && token.Status != "active" {
+		err = fmt.Errorf("token status is %q", token.Status)
+	}
+	check("cloudflare token", err)
+
+	for _, name := range zoneNames {
+		zoneID, err := api.ZoneIDByName(name)
+		check("cloudflare zone "+name, err)
+		if err != nil {
+			continue
+		}
+		writable, err := CanWrite(ctx, api, zoneID)
+		if err == nil && !writable {
+			err = fmt.Errorf("token is read-only")
+		}
+		check("cloudflare write access "+name, err)
+	}
+	return ok
+}
+
+// CanWrite reports whether the token may change records in the zone. It
+// posts a record of an invalid type, which cloudflare refuses for a read-only
+// token and rejects as invalid otherwise, so nothing is created.
+func CanWrite(ctx context.Context, api *cloudflare.API, zoneID string) (bool, error) {
+	probe := map[string]string{"type": "INVALID", "name": "cloudflare-tailscale-dns-write-probe", "content": "probe"}
+	_, err := api.Raw(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", probe, nil)
+	var denied *cloudflare.AuthenticationError
+	var invalid *cloudflare.RequestError
+	switch {
+	case errors.As(err, &denied):
+		return false, nil
+	case err == nil, errors.As(err, &invalid):
+		return true, nil
+	}
+	return false, err
+}
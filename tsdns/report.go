@@ -0,0 +1,89 @@
+package tsdns
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// runReport is the audit trail of a sync pass written with -report-out.
+type runReport struct {
+	mu sync.Mutex
+
+	Start   time.Time      `json:"start"`
+	End     time.Time      `json:"end"`
+	Counts  map[string]int `json:"counts"`
+	Actions []reportAction `json:"actions"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type reportAction struct {
+	Zone    string `json:"zone"`
+	Action  string `json:"action"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	ID      string `json:"id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func newReport() *runReport {
+	return &runReport{
+		Start:   time.Now(),
+		Counts:  make(map[string]int),
+		Actions: make([]reportAction, 0),
+	}
+}
+
+// add records an action. Failed actions are counted as "failed".
+func (r *runReport) add(zone, action, recordType, name, content, id string, err error) {
+	a := reportAction{
+		Zone:    zone,
+		Action:  action,
+		Type:    recordType,
+		Name:    name,
+		Content: content,
+		ID:      id,
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		a.Error = err.Error()
+		r.Counts["failed"]++
+	} else {
+		r.Counts[action]++
+	}
+	r.Actions = append(r.Actions, a)
+}
+
+// logFailures logs every failed action in one block, so they need not be
+// picked out of the interleaved log of a large run.
+func (r *runReport) logFailures() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Counts["failed"] == 0 {
+		return
+	}
+	log.Printf("FAILURES (%d)", r.Counts["failed"])
+	for _, a := range r.Actions {
+		if len(a.Error) > 0 {
+			log.Printf("  %s: %s %s %s: %s", a.Zone, a.Action, a.Type, a.Name, a.Error)
+		}
+	}
+	log.Print("END FAILURES")
+}
+
+// write finishes the report with the pass result and writes it to path.
+func (r *runReport) write(path string, err error) error {
+	r.End = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
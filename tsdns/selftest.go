@@ -0,0 +1,46 @@
+package tsdns
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// NameSamples are tricky tailscale hostnames for checking name construction.
+var NameSamples = []string{
+	"laptop",
+	"My MacBook Pro",
+	"UPPER-case",
+	"host.with.dots",
+	"under_score",
+	"-leading-dash",
+	"trailing-dash-",
+	"ünïcödé",
+	"emoji-😀",
+	"  spaces  around  ",
+	strings.Repeat("x", 70),
+}
+
+// PrintNames writes how each host name is turned into record names under
+// domains: the sanitized host, the templated label and every record name,
+// with any cloudflare constraint the name breaks.
+func PrintNames(out io.Writer, hosts []string, domains DomainList, tmpl *template.Template, replacement string) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INPUT\tHOST\tRECORD\tPROBLEMS")
+	for _, h := range hosts {
+		host := SanitizeHost(h, replacement)
+		label, err := renderName(tmpl, host)
+		if err != nil {
+			fmt.Fprintf(w, "%q\t%s\t\t%v\n", h, host, err)
+			continue
+		}
+		label = SanitizeHost(label, replacement)
+		for _, d := range domains {
+			name := d.BuildHostname(label)
+			fmt.Fprintf(w, "%q\t%s\t%s\t%s\n", h, host, name, strings.Join(validateName(name), "; "))
+		}
+	}
+	w.Flush()
+}
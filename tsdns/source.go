@@ -0,0 +1,161 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// Node is a tailscale device, normalized from whichever source listed it.
+type Node struct {
+	HostName string
+	// DNSName is the MagicDNS name, without the trailing dot.
+	DNSName string
+	// MagicDNSSuffix is the tailnet's MagicDNS suffix, if the source knows
+	// it.
+	MagicDNSSuffix string
+	IPs            []netip.Addr
+	Tags           []string
+	OS             string
+	Online         bool
+	// Self is set for the node the program runs on. Only the local source
+	// knows which node that is.
+	Self bool
+	// LastSeen is when an offline node was last connected, if known.
+	LastSeen time.Time
+	// KeyExpiry is when the node key expires, zero if it never does.
+	KeyExpiry time.Time
+	// Endpoint is the address tailscaled currently talks to the node at
+	// directly, invalid when relayed or unknown. Only the local source
+	// knows it.
+	Endpoint netip.Addr
+	// ExitNode is set for nodes offering an approved exit node, whether or
+	// not they also route subnets. Only the local source knows it.
+	ExitNode bool
+	// Caps are the node capabilities granted to the node, nil when the
+	// source does not report them.
+	Caps []string
+	// Groups are the policy file groups of the node's owner, nil when the
+	// source does not report them.
+	Groups []string
+	// Attrs are the device posture attributes, nil when the source does not
+	// report them.
+	Attrs map[string]string
+}
+
+// NodeSource lists the devices in the tailnet.
+type NodeSource interface {
+	Nodes(ctx context.Context) ([]Node, error)
+}
+
+// LocalSource reads nodes from the local tailscaled. It is the only source
+// that reports the local node, with Self set.
+type LocalSource struct{}
+
+// Nodes returns the local node and its peers.
+func (LocalSource) Nodes(ctx context.Context) ([]Node, error) {
+	status, err := tailscale.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return statusNodes(status), nil
+}
+
+// StatusFileSource reads nodes from a recorded `tailscale status --json`,
+// for reproducing a sync offline.
+type StatusFileSource struct {
+	Path string
+}
+
+// Nodes returns the recorded local node and its peers.
+func (s StatusFileSource) Nodes(ctx context.Context) ([]Node, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	var status ipnstate.Status
+	if err := json.Unmarshal(b, &status); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.Path, err)
+	}
+	if status.Self == nil && len(status.Peer) == 0 {
+		return nil, fmt.Errorf("%s: no Self or Peer nodes, expected the output of tailscale status --json", s.Path)
+	}
+	return statusNodes(&status), nil
+}
+
+func statusNodes(status *ipnstate.Status) []Node {
+	var suffix string
+	if status.CurrentTailnet != nil {
+		suffix = status.CurrentTailnet.MagicDNSSuffix
+	}
+	nodes := make([]Node, 0, 1+len(status.Peer))
+	// tailscaled reports no Self while it is starting or logged out.
+	if status.Self != nil {
+		self := peerNode(status.Self)
+		self.Self = true
+		nodes = append(nodes, self)
+	} else {
+		log.Print("warning: tailscale status has no local node, adding no records for it")
+	}
+	for _, peer := range status.Peer {
+		nodes = append(nodes, peerNode(peer))
+	}
+	for i := range nodes {
+		nodes[i].MagicDNSSuffix = suffix
+	}
+	return nodes
+}
+
+func peerNode(peer *ipnstate.PeerStatus) Node {
+	n := Node{
+		HostName: peer.HostName,
+		DNSName:  strings.TrimSuffix(peer.DNSName, "."),
+		IPs:      peer.TailscaleIPs,
+		OS:       peer.OS,
+		Online:   peer.Online,
+		LastSeen: peer.LastSeen,
+		ExitNode: peer.ExitNodeOption,
+	}
+	if peer.KeyExpiry != nil {
+		n.KeyExpiry = *peer.KeyExpiry
+	}
+	n.Caps = make([]string, 0, len(peer.Capabilities)+len(peer.CapMap))
+	for _, c := range peer.Capabilities {
+		n.Caps = append(n.Caps, string(c))
+	}
+	for c := range peer.CapMap {
+		if !slices.Contains(n.Caps, string(c)) {
+			n.Caps = append(n.Caps, string(c))
+		}
+	}
+	if ap, err := netip.ParseAddrPort(peer.CurAddr); err == nil {
+		n.Endpoint = ap.Addr().Unmap()
+	}
+	if peer.Tags != nil {
+		n.Tags = peer.Tags.AsSlice()
+	}
+	return n
+}
+
+// magicDNSLabel returns the host label of a MagicDNS name by stripping the
+// tailnet suffix. Without a known suffix the first label is used.
+func magicDNSLabel(dnsName, suffix string) string {
+	dnsName = strings.TrimSuffix(dnsName, ".")
+	suffix = strings.Trim(suffix, ".")
+	if len(suffix) > 0 {
+		if label, ok := strings.CutSuffix(strings.ToLower(dnsName), "."+strings.ToLower(suffix)); ok {
+			return label
+		}
+	}
+	label, _, _ := strings.Cut(dnsName, ".")
+	return label
+}
@@ -0,0 +1,72 @@
+package tsdns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestMagicDNSLabel(t *testing.T) {
+	tests := []struct {
+		dnsName, suffix, want string
+	}{
+		{"host.tailnet-name.ts.net.", "tailnet-name.ts.net", "host"},
+		{"host.tailnet-name.ts.net", ".tailnet-name.ts.net.", "host"},
+		{"Host.Tailnet-Name.ts.net.", "tailnet-name.ts.net", "host"},
+		{"a.b.tailnet-name.ts.net.", "tailnet-name.ts.net", "a.b"},
+		{"host.other.ts.net.", "tailnet-name.ts.net", "host"},
+		{"host.tailnet-name.ts.net.", "", "host"},
+		{"tailnet-name.ts.net.", "tailnet-name.ts.net", "tailnet-name"},
+	}
+	for _, tt := range tests {
+		if got := magicDNSLabel(tt.dnsName, tt.suffix); got != tt.want {
+			t.Errorf("magicDNSLabel(%q, %q) = %q, want %q", tt.dnsName, tt.suffix, got, tt.want)
+		}
+	}
+}
+
+func TestRunOnceMagicDNSSuffixFromStatus(t *testing.T) {
+	api := newFakeDNS()
+	n := peer("unreliable-name", "100.64.0.5")
+	n.DNSName = "nas.tail1234.ts.net."
+	n.MagicDNSSuffix = "tail1234.ts.net"
+	s := testSyncer(api, n)
+	s.MagicDNS = true
+	runOnce(t, s)
+	if records := api.byName("nas.wg.example.com"); len(records) != 1 {
+		t.Errorf("records = %+v, want one named after the MagicDNS label", api.list())
+	}
+}
+
+func TestStatusNodesWithoutSelf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	status := `{"Self": null, "Peer": {"nodekey:0101010101010101010101010101010101010101010101010101010101010101": {"HostName": "nas", "TailscaleIPs": ["100.64.0.2"], "Online": true, "Tags": ["tag:dns"]}}}`
+	if err := os.WriteFile(path, []byte(status), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := StatusFileSource{Path: path}.Nodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Self || nodes[0].HostName != "nas" {
+		t.Fatalf("nodes = %+v, want only the peer", nodes)
+	}
+
+	api := newFakeDNS()
+	s := testSyncer(api)
+	s.Source = StatusFileSource{Path: path}
+	runOnce(t, s)
+	if records := api.list(); len(records) != 1 || records[0].Name != "nas.wg.example.com" {
+		t.Errorf("records = %+v, want only the peer's", records)
+	}
+}
+
+func TestStatusNodesNilSelf(t *testing.T) {
+	nodes := statusNodes(&ipnstate.Status{})
+	if len(nodes) != 0 {
+		t.Errorf("nodes = %+v, want none", nodes)
+	}
+}
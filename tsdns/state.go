@@ -0,0 +1,86 @@
+package tsdns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+type stateRecord struct {
+	ZoneID  string `json:"zone_id"`
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	// SeenAt is when a pass last wanted the record.
+	SeenAt time.Time `json:"seen_at"`
+}
+
+func newStateRecord(zoneID string, r cloudflare.DNSRecord) stateRecord {
+	return stateRecord{
+		ZoneID:  zoneID,
+		ID:      r.ID,
+		Type:    r.Type,
+		Name:    r.Name,
+		Content: r.Content,
+		TTL:     r.TTL,
+		SeenAt:  time.Now().UTC(),
+	}
+}
+
+// staleState returns the age of the file at path and whether it is older
+// than StateMaxAge. A missing file is not stale.
+func (s *Syncer) staleState(path string) (time.Duration, bool, error) {
+	if s.StateMaxAge <= 0 || len(path) == 0 {
+		return 0, false, nil
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	age := time.Since(fi.ModTime())
+	return age, age > s.StateMaxAge, nil
+}
+
+// readState reads a state file written by writeState, keyed by record id. A
+// missing file is an empty state.
+func readState(path string) (map[string]stateRecord, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state []stateRecord
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	byID := make(map[string]stateRecord, len(state))
+	for _, r := range state {
+		byID[r.ID] = r
+	}
+	return byID, nil
+}
+
+// writeState writes the records managed by this run, as returned by
+// cloudflare, to path as json.
+func writeState(path string, state []stateRecord) error {
+	if state == nil {
+		state = make([]stateRecord, 0)
+	}
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
@@ -0,0 +1,1397 @@
+package tsdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Zone is a cloudflare zone and the domains whose records live in it.
+type Zone struct {
+	ID      string
+	Name    string
+	Domains DomainList
+}
+
+// Syncer holds everything needed for a sync pass. A pass reads the current
+// tailscale devices and cloudflare records and reconciles them. API, Source,
+// Zones and a valid TTL are required; every other field is off or uses a
+// default when left zero.
+type Syncer struct {
+	// API is usually a *cloudflare.API, see DNSClient.
+	API    DNSClient
+	Source NodeSource
+	Zones  []Zone
+	// Fixture replaces the records listed from cloudflare, see LoadRecords.
+	// It needs DryRun, as the records do not exist.
+	Fixture []cloudflare.DNSRecord
+
+	// Tag selects peers with this tag. Peers are always filtered by tag, so
+	// an empty Tag selects none of them.
+	Tag      string
+	OSFilter []string
+	// Group selects nodes whose owner is in this policy file group, when the
+	// source reports groups.
+	Group string
+	// Attrs are posture attribute filters, keyed by attribute name.
+	Attrs map[string]string
+	// Capability selects peers granted this node capability, when the
+	// source reports capabilities.
+	Capability string
+	// ExitNodesOnly selects peers offering an exit node.
+	ExitNodesOnly bool
+	SkipSelf      bool
+	// SelfRespectsTag filters the local node like any peer.
+	SelfRespectsTag bool
+	// AlwaysInclude lists host names selected regardless of the filters,
+	// like the local node. They must still be online, and SkipSelf and
+	// ExcludeExpired still apply.
+	AlwaysInclude []string
+	// RequireSelfTag fails every sync before any change unless the local
+	// node carries this tag.
+	RequireSelfTag string
+	// MagicDNS names hosts after their MagicDNS label instead of the
+	// hostname. MagicDNSSuffix is stripped from the MagicDNS name, or
+	// detected from the source when empty.
+	MagicDNS       bool
+	MagicDNSSuffix string
+	// ExcludeExpired skips nodes whose key has expired. WarnExpiring logs
+	// nodes whose key expires within that duration.
+	ExcludeExpired bool
+	WarnExpiring   time.Duration
+	// OrphanAfter keeps the records of an offline node until it has not been
+	// seen for this long. Records of nodes that left the tailnet are kept
+	// for as long after StateFile last listed them.
+	OrphanAfter time.Duration
+	// OnlineThreshold and OfflineThreshold are the consecutive passes a peer
+	// must be online before it gets records, and offline before it loses
+	// them. PeerStateFile keeps the counts between runs; without it they
+	// only last as long as the Syncer, e.g. during Watch.
+	OnlineThreshold  int
+	OfflineThreshold int
+	PeerStateFile    string
+	// AllowNonTailnetIP publishes node addresses outside tailscale's ranges.
+	// By default they are skipped with a warning.
+	AllowNonTailnetIP bool
+	// Limit keeps only the first Limit host names, 0 for no limit.
+	Limit int
+	// TagSubdomains are tags whose name, without the "tag:" prefix, is
+	// inserted as a label after the host name of peers carrying them, e.g.
+	// host.prod.example.com for tag:prod. A host matching several of them
+	// fails the pass unless TagSubdomainEach creates one record per tag.
+	TagSubdomains    []string
+	TagSubdomainEach bool
+
+	// Aliases maps a host name to extra names, see ParseAliases.
+	Aliases map[string][]string
+	// Overrides is keyed by host name, see ParseOverride.
+	Overrides map[string][]Host
+	// ExternalOverrides is the content of hosts under External domains,
+	// keyed like Overrides.
+	ExternalOverrides map[string][]Host
+	// ProxiedTag proxies the records of peers carrying this tag, as if
+	// Proxied was set for them.
+	ProxiedTag string
+	// EndpointSuffix, when set, adds a record named after the host with this
+	// suffix for the node's direct Endpoint.
+	EndpointSuffix string
+	// MapIPToName, when set, names the records of tailscale addresses
+	// IPName(MapIPToName, ip) instead of after the host. Templates, aliases,
+	// overrides and the config then see that name. TagTemplates are not
+	// applied.
+	MapIPToName string
+	// HostsTXT, when set, adds a TXT record with this label listing the
+	// labels of all hosts, for discovery.
+	HostsTXT string
+	// SanitizeReplacement replaces characters that are not allowed in record
+	// names, see SanitizeHost. Empty removes them.
+	SanitizeReplacement string
+	// Catchall names the host the wildcard record *.<domain> points at. The
+	// wildcard is kept while the host has no records.
+	Catchall string
+	// TagTemplates replaces NameTemplate for hosts carrying the tag, keyed
+	// by tag.
+	TagTemplates map[string]*template.Template
+	// MigrateFrom is the NameTemplate records were built with before. Owned
+	// records under a host's old name are renamed to the new one instead of
+	// becoming orphans.
+	MigrateFrom *template.Template
+	// NameTemplate renders the host part of record names, see
+	// ParseNameTemplate. Nil uses the host name as is.
+	NameTemplate *template.Template
+	// TTL applies to unproxied records, proxied ones always use automatic.
+	// TTL 0 keeps the ttl of existing records and lets cloudflare pick one
+	// for new records. TypeTTL overrides TTL by record type, e.g. "AAAA".
+	TTL        int
+	TypeTTL    map[string]int
+	Proxied    bool
+	ExpandIPv6 bool
+	Config     *Config
+	// PerPage is the page size for listing records, see ListDNSRecords.
+	PerPage int
+
+	// Adopt marks unmanaged records matching it as managed.
+	// ClobberForeign overwrites unmanaged records a host claims. Otherwise
+	// only owned and adoptable records are updated.
+	ClobberForeign   bool
+	Adopt            *regexp.Regexp
+	Concurrency      int
+	ZoneConcurrency  int
+	RemoveOrphans    bool
+	ProtectThreshold int
+	// PruneOnly skips creates and updates, so a pass only removes orphans.
+	PruneOnly bool
+	// MaxCreates aborts a zone's sync before any change when it would create
+	// more records than this. 0 is unlimited.
+	MaxCreates int
+	// SinceBootstrap never removes orphans before StateFile exists.
+	SinceBootstrap bool
+	// StateMaxAge ignores a StateFile or PeerStateFile last written longer
+	// ago than this, and removes no orphans while StateFile is that old. 0
+	// never expires.
+	StateMaxAge time.Duration
+	StateFile   string
+	ReportFile  string
+	// AuditLog, when set, is appended a json line for every applied change.
+	AuditLog string
+	// InstanceID is encoded in the owner marker. Orphan removal only considers
+	// records of the same instance, so syncs with different ids can share a
+	// domain. See ValidInstanceID.
+	InstanceID string
+	// Replace deletes and recreates records that need changes instead of
+	// updating them in place, and removes managed records of a host's name
+	// whose type is no longer wanted, e.g. the A record of a host that became
+	// AAAA only.
+	Replace bool
+	// Batch sends record changes in batch requests, falling back to one
+	// request per change when a batch fails.
+	Batch bool
+	// DryRun logs the planned changes instead of applying them and checks
+	// planned records against cloudflare's constraints. The state file is
+	// not written.
+	DryRun bool
+	// Metrics, when set, accumulates the results of every pass.
+	Metrics *Metrics
+	// WatchJitter adds a random delay of up to WatchJitter to every wait
+	// between Watch passes, so instances started together spread out.
+	WatchJitter time.Duration
+	// WatchErrorInterval, when set, retries a failed Watch pass after this
+	// long instead of the full interval, doubling the wait on each further
+	// failure up to the interval.
+	WatchErrorInterval time.Duration
+	// Verbose logs unchanged records too. Otherwise only changes are logged,
+	// followed by a count of the unchanged records.
+	Verbose bool
+
+	// recent holds records created by earlier passes, keyed by id, until a
+	// listing returns them.
+	recentMu sync.Mutex
+	recent   map[string]recentRecord
+
+	// auditMu serializes appends to -audit-log.
+	auditMu sync.Mutex
+
+	// peers counts each peer's consecutive online and offline passes for
+	// -online-threshold and -offline-threshold, keyed by host name.
+	peers map[string]peerRuns
+}
+
+// recentGrace is how long a created record is assumed to exist while
+// cloudflare listings do not return it yet.
+const recentGrace = 5 * time.Minute
+
+type recentRecord struct {
+	zoneID  string
+	record  cloudflare.DNSRecord
+	created time.Time
+}
+
+// Hosts returns the tailscale hosts that should have records, including
+// aliases. truncated is set when Limit dropped hosts.
+func (s *Syncer) Hosts(ctx context.Context) (hostList []Host, truncated bool, err error) {
+	hostList, truncated, _, err = s.hosts(ctx)
+	return hostList, truncated, err
+}
+
+// hosts is Hosts, also reporting whether a stale PeerStateFile was ignored.
+func (s *Syncer) hosts(ctx context.Context) (hostList []Host, truncated, peersStale bool, err error) {
+	nodes, err := s.Source.Nodes(ctx)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if len(s.RequireSelfTag) > 0 {
+		if err := requireSelfTag(nodes, s.RequireSelfTag); err != nil {
+			return nil, false, false, err
+		}
+	}
+	var settled map[string]bool
+	if s.hysteresis() {
+		if settled, peersStale, err = s.settleOnline(nodes); err != nil {
+			return nil, false, false, err
+		}
+	}
+	hostList = make([]Host, 0, len(nodes))
+	// tagTemplates holds the TagTemplates entry of hosts that match one.
+	tagTemplates := make(map[string]*template.Template)
+	for _, n := range nodes {
+		if online, ok := settled[n.HostName]; ok {
+			// The settled state replaces the reported one, including the
+			// OrphanAfter grace it already accounts for.
+			n.Online, n.LastSeen = online, time.Time{}
+		}
+		switch reason := s.skipReason(n); reason {
+		case "":
+		case reasonExpired:
+			log.Printf("skipping host %s, its key expired at %s", n.HostName, n.KeyExpiry.Format(time.RFC3339))
+			continue
+		default:
+			continue
+		}
+		if left := time.Until(n.KeyExpiry); !n.KeyExpiry.IsZero() && left > 0 && left < s.WarnExpiring {
+			log.Printf("warning: key of host %s expires at %s", n.HostName, n.KeyExpiry.Format(time.RFC3339))
+		}
+		name := n.HostName
+		if s.MagicDNS && len(n.DNSName) > 0 {
+			suffix := s.MagicDNSSuffix
+			if len(suffix) == 0 {
+				suffix = n.MagicDNSSuffix
+			}
+			name = magicDNSLabel(n.DNSName, suffix)
+		}
+		segments, err := s.tagSegments(n)
+		if err != nil {
+			return nil, false, false, err
+		}
+		tmpl, err := s.tagTemplate(n, s.sanitize(name))
+		if err != nil {
+			return nil, false, false, err
+		}
+		if tmpl != nil {
+			tagTemplates[s.sanitize(name)] = tmpl
+		}
+		ips, ignored := s.addrs(n)
+		for _, ip := range ignored {
+			log.Printf("warning: host %s has address %s outside the tailscale ranges, skipping it", n.HostName, ip)
+		}
+		proxied := hasTag(n.Tags, s.ProxiedTag)
+		for _, segment := range segments {
+			for _, ip := range ips {
+				ipName := s.sanitize(name)
+				if len(s.MapIPToName) > 0 {
+					ipName = IPName(s.MapIPToName, ip)
+				}
+				hostList = append(hostList, Host{
+					Name:    ipName,
+					IP:      ip,
+					Segment: segment,
+					Proxied: proxied,
+				})
+			}
+			if len(s.EndpointSuffix) > 0 && n.Endpoint.IsValid() {
+				hostList = append(hostList, Host{
+					Name:    s.sanitize(name + s.EndpointSuffix),
+					IP:      n.Endpoint,
+					Segment: segment,
+					Proxied: proxied,
+				})
+			}
+		}
+	}
+
+	if s.Limit > 0 {
+		hostList, truncated = limitHosts(hostList, s.Limit)
+	}
+
+	aliasList := make([]Host, 0)
+	for _, host := range hostList {
+		if len(s.Catchall) > 0 && host.Name == s.sanitize(s.Catchall) {
+			aliasList = append(aliasList, Host{
+				Name:    "*",
+				IP:      host.IP,
+				Segment: host.Segment,
+				Proxied: host.Proxied,
+			})
+		}
+		for _, a := range resolveAliases(s.Aliases, host.Name) {
+			aliasList = append(aliasList, Host{
+				Name:    s.sanitize(a),
+				IP:      host.IP,
+				Segment: host.Segment,
+				Proxied: host.Proxied,
+			})
+		}
+	}
+	for i := range hostList {
+		tmpl := s.NameTemplate
+		if t, ok := tagTemplates[hostList[i].Name]; ok {
+			tmpl = t
+		}
+		name, err := renderName(tmpl, hostList[i].Name)
+		if err != nil {
+			return nil, false, false, fmt.Errorf("unable to build name for host %s: %w", hostList[i].Name, err)
+		}
+		if s.MigrateFrom != nil {
+			legacy, err := renderName(s.MigrateFrom, hostList[i].Name)
+			if err != nil {
+				return nil, false, false, fmt.Errorf("unable to build legacy name for host %s: %w", hostList[i].Name, err)
+			}
+			if legacy = s.sanitize(legacy); legacy != s.sanitize(name) {
+				hostList[i].Legacy = legacy
+			}
+		}
+		hostList[i].Name = s.sanitize(name)
+	}
+	// An alias named like a host would put both behind one name, so the
+	// host wins.
+	taken := make(map[string]bool, len(hostList))
+	for _, h := range hostList {
+		taken[h.Label()] = true
+	}
+	warned := make(map[string]bool)
+	aliasList = slices.DeleteFunc(aliasList, func(a Host) bool {
+		if !taken[a.Label()] {
+			return false
+		}
+		if !warned[a.Label()] {
+			warned[a.Label()] = true
+			log.Printf("warning: alias %s has the name of a host, skipping the alias", a.Label())
+		}
+		return true
+	})
+	hostList = applyOverrides(append(hostList, aliasList...), s.sanitizeOverrides(s.Overrides))
+	if len(s.HostsTXT) > 0 {
+		if txt, ok := s.hostsTXT(hostList); ok {
+			hostList = append(hostList, txt)
+		}
+	}
+	hostList = append(hostList, s.Config.staticHosts()...)
+	sortHosts(hostList)
+	return hostList, truncated, peersStale, nil
+}
+
+// checkSelfTag enforces RequireSelfTag for operations that do not list hosts.
+func (s *Syncer) checkSelfTag(ctx context.Context) error {
+	if len(s.RequireSelfTag) == 0 {
+		return nil
+	}
+	nodes, err := s.Source.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	return requireSelfTag(nodes, s.RequireSelfTag)
+}
+
+// requireSelfTag returns an error unless the local node carries tag.
+func requireSelfTag(nodes []Node, tag string) error {
+	for _, n := range nodes {
+		if n.Self {
+			if !slices.Contains(n.Tags, tag) {
+				return fmt.Errorf("local node %s does not carry required tag %s", n.HostName, tag)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("required tag %s cannot be checked, the source does not report the local node", tag)
+}
+
+const reasonExpired = "key expired"
+
+// skipReason returns why n gets no records, or "" if it is selected.
+func (s *Syncer) skipReason(n Node) string {
+	if n.Self && s.SkipSelf {
+		return "local node, skip-self"
+	}
+	// Only the local node is published whether or not it is online.
+	if !(n.Online || s.recentlySeen(n)) && (!n.Self || s.SelfRespectsTag) {
+		return "offline"
+	}
+	if !s.alwaysIncluded(n) {
+		switch {
+		case !matchesOS(n.OS, s.OSFilter):
+			return "os " + n.OS + " not selected"
+		case len(s.Tag) == 0:
+			return "no -tag given"
+		case !hasTag(n.Tags, s.Tag):
+			return "not tagged " + s.Tag
+		case !matchesAttrs(n.Attrs, s.Attrs):
+			return "attributes do not match"
+		case len(s.Group) > 0 && n.Groups != nil && !slices.Contains(n.Groups, s.Group):
+			return "owner not in " + s.Group
+		case len(s.Capability) > 0 && n.Caps != nil && !slices.Contains(n.Caps, s.Capability):
+			return "no capability " + s.Capability
+		case s.ExitNodesOnly && !n.ExitNode:
+			return "not an exit node"
+		}
+	}
+	if !n.KeyExpiry.IsZero() && s.ExcludeExpired && !time.Now().Before(n.KeyExpiry) {
+		return reasonExpired
+	}
+	return ""
+}
+
+// sanitizeOverrides returns overrides keyed by sanitized host name.
+func (s *Syncer) sanitizeOverrides(overrides map[string][]Host) map[string][]Host {
+	out := make(map[string][]Host, len(overrides))
+	for _, o := range overrides {
+		for _, h := range o {
+			h.Name = s.sanitize(h.Name)
+			out[h.Name] = append(out[h.Name], h)
+		}
+	}
+	return out
+}
+
+// alwaysIncluded reports whether n bypasses the tag and other selection
+// filters.
+func (s *Syncer) alwaysIncluded(n Node) bool {
+	if n.Self && !s.SelfRespectsTag {
+		return true
+	}
+	return slices.ContainsFunc(s.AlwaysInclude, func(h string) bool {
+		return strings.EqualFold(h, n.HostName)
+	})
+}
+
+// addrs splits n's addresses into the ones to publish and the ones ignored
+// for being outside the tailscale ranges.
+func (s *Syncer) addrs(n Node) (ips, ignored []netip.Addr) {
+	for _, ip := range n.IPs {
+		if !s.AllowNonTailnetIP && !tailnetIP(ip) {
+			ignored = append(ignored, ip)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips, ignored
+}
+
+func (s *Syncer) sanitize(name string) string {
+	return SanitizeHost(name, s.SanitizeReplacement)
+}
+
+// recentlySeen reports whether an offline node was seen within OrphanAfter.
+func (s *Syncer) recentlySeen(n Node) bool {
+	return s.OrphanAfter > 0 && !n.LastSeen.IsZero() && time.Since(n.LastSeen) < s.OrphanAfter
+}
+
+// tagSegments returns the segments to build n's records under: one per
+// matching TagSubdomains tag, or a single empty one.
+func (s *Syncer) tagSegments(n Node) ([]string, error) {
+	var segments []string
+	for _, tag := range s.TagSubdomains {
+		if slices.Contains(n.Tags, tag) {
+			segments = append(segments, strings.TrimPrefix(tag, "tag:"))
+		}
+	}
+	switch {
+	case len(segments) == 0:
+		return []string{""}, nil
+	case len(segments) > 1 && !s.TagSubdomainEach:
+		return nil, fmt.Errorf("host %s matches several subdomain tags: %s", n.HostName, strings.Join(segments, ", "))
+	}
+	return segments, nil
+}
+
+// tagTemplate returns the TagTemplates entry for n's tags, or nil. Several
+// matching templates must render the same name.
+func (s *Syncer) tagTemplate(n Node, host string) (*template.Template, error) {
+	var tmpl *template.Template
+	var name, tag string
+	for _, t := range n.Tags {
+		candidate, ok := s.TagTemplates[t]
+		if !ok {
+			continue
+		}
+		rendered, err := renderName(candidate, host)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build name for host %s with template of %s: %w", n.HostName, t, err)
+		}
+		if tmpl != nil && rendered != name {
+			return nil, fmt.Errorf("host %s gets conflicting names from the templates of %s (%s) and %s (%s)", n.HostName, tag, name, t, rendered)
+		}
+		tmpl, name, tag = candidate, rendered, t
+	}
+	return tmpl, nil
+}
+
+// hasTag reports whether tag is one of tags. An empty tag matches nothing.
+func hasTag(tags []string, tag string) bool {
+	return tag != "" && slices.Contains(tags, tag)
+}
+
+// matchesAttrs reports whether attrs has every wanted attribute value. A nil
+// attrs means the source does not report attributes and matches anything.
+func matchesAttrs(attrs, want map[string]string) bool {
+	if attrs == nil {
+		return true
+	}
+	for k, v := range want {
+		if have, ok := attrs[k]; !ok || have != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sortHosts orders hosts by name, then record type and content, so passes
+// process records in a stable order.
+func sortHosts(hosts []Host) {
+	slices.SortFunc(hosts, func(a, b Host) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Segment, b.Segment); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.RecordType(), b.RecordType()); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Target, b.Target); c != 0 {
+			return c
+		}
+		return a.IP.Compare(b.IP)
+	})
+}
+
+// limitHosts sorts hosts by name and ip and keeps the addresses of the first n
+// distinct host names.
+func limitHosts(hosts []Host, n int) ([]Host, bool) {
+	sortHosts(hosts)
+	names := 0
+	for i, h := range hosts {
+		if i == 0 || h.Name != hosts[i-1].Name {
+			names++
+		}
+		if names > n {
+			log.Printf("peer limit %d reached, skipping %d of %d host addresses", n, len(hosts)-i, len(hosts))
+			return hosts[:i], true
+		}
+	}
+	return hosts, false
+}
+
+// content returns the record content for t, honoring -ipv6-format.
+func (s *Syncer) content(t Host) string {
+	if len(t.Target) > 0 {
+		return t.Target
+	}
+	if t.IP.Is6() && s.ExpandIPv6 {
+		return t.IP.StringExpanded()
+	}
+	return t.IP.String()
+}
+
+// RunOnce performs a single sync pass.
+func (s *Syncer) RunOnce(ctx context.Context) (err error) {
+	rep := newReport()
+	var zoneState [][]stateRecord
+	if s.Metrics != nil {
+		defer func() {
+			s.Metrics.observe(s.Zones, zoneState, rep, err)
+		}()
+	}
+	if len(s.ReportFile) > 0 {
+		defer func() {
+			if werr := rep.write(s.ReportFile, err); werr != nil {
+				log.Printf("unable to write report %s: %v", s.ReportFile, werr)
+			}
+		}()
+	}
+
+	removeOrphans := s.RemoveOrphans
+	if s.SinceBootstrap {
+		if _, err := os.Stat(s.StateFile); errors.Is(err, fs.ErrNotExist) {
+			if removeOrphans {
+				log.Printf("state file %s does not exist, not removing orphans on first run", s.StateFile)
+			}
+			removeOrphans = false
+		} else if err != nil {
+			return err
+		}
+	}
+
+	age, stale, err := s.staleState(s.StateFile)
+	if err != nil {
+		return err
+	}
+	if stale {
+		log.Printf("state file %s was written %s ago, ignoring it and not removing orphans", s.StateFile, age.Round(time.Second))
+		removeOrphans = false
+	}
+
+	hostList, truncated, peersStale, err := s.hosts(ctx)
+	if err != nil {
+		return err
+	}
+	if peersStale && removeOrphans {
+		log.Print("not removing orphans because the peer state was stale")
+		removeOrphans = false
+	}
+	if truncated && removeOrphans {
+		log.Print("not removing orphans because -peer-limit skipped hosts")
+		removeOrphans = false
+	}
+
+	defer func() {
+		rep.logFailures()
+		if errors.Is(err, context.Canceled) {
+			log.Printf("sync interrupted after %d created, %d updated, %d removed", rep.Counts["created"], rep.Counts["updated"]+rep.Counts["replaced"], rep.Counts["removed"])
+		}
+	}()
+
+	var prev map[string]stateRecord
+	if len(s.StateFile) > 0 && !stale {
+		if prev, err = readState(s.StateFile); err != nil {
+			return err
+		}
+	}
+
+	zoneState = make([][]stateRecord, len(s.Zones))
+	err = forEachLimit(ctx, s.ZoneConcurrency, len(s.Zones), func(i int) error {
+		records, err := s.syncZone(ctx, s.Zones[i], hostList, removeOrphans, prev, rep)
+		zoneState[i] = records
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var state []stateRecord
+	for _, records := range zoneState {
+		state = append(state, records...)
+	}
+	if !s.Verbose {
+		log.Printf("%d records unchanged", rep.Counts["unchanged"])
+	}
+
+	if len(s.StateFile) > 0 && !s.DryRun {
+		if err := writeState(s.StateFile, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordOp is a planned change to a single record. For updates, adoptions
+// and removals record carries the id of the existing record.
+type recordOp struct {
+	action string // created, updated, replaced, migrated, adopted or removed
+	record cloudflare.DNSRecord
+	// old is the content the record had before the op, if any.
+	old string
+}
+
+// legacyRecord returns an unclaimed owned record of t under its MigrateFrom
+// name, if one exists.
+func (s *Syncer) legacyRecord(d DNSDomain, t Host, current map[string][]cloudflare.DNSRecord, matched map[string]struct{}) (cloudflare.DNSRecord, bool) {
+	if len(t.Legacy) == 0 {
+		return cloudflare.DNSRecord{}, false
+	}
+	legacy := t
+	legacy.Name = t.Legacy
+	for _, r := range current[strings.ToLower(t.RecordType()+d.BuildHostname(legacy.Label()))] {
+		if _, ok := matched[r.ID]; !ok && owned(s.InstanceID, r) {
+			return r, true
+		}
+	}
+	return cloudflare.DNSRecord{}, false
+}
+
+// protected reports whether r is the zone's apex record or an NS or SOA
+// record, which are never removed whatever the flags.
+func (z Zone) protected(r cloudflare.DNSRecord) bool {
+	return r.Type == "NS" || r.Type == "SOA" || strings.EqualFold(strings.TrimSuffix(r.Name, "."), z.Name)
+}
+
+// syncZone reconciles the records of hostList under the zone's domains and
+// returns the managed records. prev is the previous state, used to delay the
+// removal of orphans with OrphanAfter and to find orphans of earlier syncs.
+func (s *Syncer) syncZone(ctx context.Context, z Zone, hostList []Host, removeOrphans bool, prev map[string]stateRecord, rep *runReport) ([]stateRecord, error) {
+	// Records of the previous state outside the domains, e.g. under a
+	// dropped subdomain, are only listed with the whole zone.
+	all := false
+	for _, p := range prev {
+		if p.ZoneID == z.ID && !hasAnySuffix(p.Name, z.nameSuffixes()) {
+			all = true
+		}
+	}
+	currentRecords, err := s.listRecords(ctx, z, all)
+	if err != nil {
+		return nil, err
+	}
+	currentRecords = s.mergeRecent(z.ID, currentRecords)
+
+	// Records are matched by type, name and content, so a host can have
+	// several records of a type, e.g. two ipv4 addresses.
+	currentByKey := make(map[string][]cloudflare.DNSRecord, len(currentRecords))
+	for _, r := range currentRecords {
+		key := strings.ToLower(r.Type + r.Name)
+		currentByKey[key] = append(currentByKey[key], r)
+	}
+	// matched holds the ids of the records claimed by a desired record.
+	matched := make(map[string]struct{}, len(currentRecords))
+
+	type plannedRecord struct {
+		domain   DNSDomain
+		host     Host
+		desired  cloudflare.DNSRecord
+		opts     RecordOptions
+		existing *cloudflare.DNSRecord
+	}
+	// names holds the desired record names, for -replace.
+	names := make(map[string]struct{}, len(z.Domains)*len(hostList))
+	var plan []*plannedRecord
+	external := s.sanitizeOverrides(s.ExternalOverrides)
+	// disabled holds the names of hosts disabled in the config, whose owned
+	// records are removed.
+	disabled := make(map[string]struct{})
+	for _, d := range z.Domains {
+		hosts := hostList
+		if d.External {
+			hosts = externalHosts(hostList, external)
+		}
+		for _, t := range hosts {
+			if !s.Config.HostEnabled(t.Name) {
+				disabled[strings.ToLower(d.BuildHostname(t.Label()))] = struct{}{}
+				continue
+			}
+			opts := s.Config.HostOptions(t.Name)
+			proxied := s.Proxied || t.Proxied
+			if opts.Proxied != nil {
+				proxied = *opts.Proxied
+			}
+			// Cloudflare only proxies address and cname records.
+			if rt := t.RecordType(); rt != "A" && rt != "AAAA" && rt != "CNAME" {
+				proxied = false
+			}
+			ttl := s.TTL
+			if typeTTL, ok := s.TypeTTL[t.RecordType()]; ok {
+				ttl = typeTTL
+			}
+			if proxied {
+				ttl = 1
+			}
+			desired := cloudflare.DNSRecord{
+				Type:     t.RecordType(),
+				Name:     d.BuildHostname(t.Label()),
+				Content:  s.content(t),
+				TTL:      ttl,
+				Proxied:  &proxied,
+				Comment:  ownerComment(s.InstanceID, opts.Comment),
+				Tags:     opts.Tags,
+				Priority: opts.Priority,
+				Settings: opts.Settings,
+			}
+			if len(opts.Data) > 0 {
+				desired.Data = opts.Data
+			}
+			names[strings.ToLower(desired.Name)] = struct{}{}
+			plan = append(plan, &plannedRecord{domain: d, host: t, desired: desired, opts: opts})
+		}
+	}
+	// Records with the desired content are matched first, the remaining
+	// records of a name are then updated to the remaining contents.
+	claim := func(p *plannedRecord, exact bool) {
+		for _, r := range currentByKey[strings.ToLower(p.desired.Type+p.desired.Name)] {
+			if _, ok := matched[r.ID]; ok || (exact && !sameContent(r, p.host)) {
+				continue
+			}
+			matched[r.ID] = struct{}{}
+			p.existing = &r
+			return
+		}
+	}
+	for _, p := range plan {
+		claim(p, true)
+	}
+	for _, p := range plan {
+		if p.existing == nil {
+			claim(p, false)
+		}
+	}
+
+	managedRecords := make([]stateRecord, 0, len(plan))
+	var upserts []recordOp
+	for _, p := range plan {
+		desired := p.desired
+		if existing := p.existing; existing != nil {
+			if !s.ClobberForeign && !owned(s.InstanceID, *existing) && !adoptable(s.Adopt, *existing) {
+				log.Printf("refusing to overwrite unmanaged record type %s, host %s, content %s, id %s", existing.Type, existing.Name, existing.Content, existing.ID)
+				rep.add(z.Name, "refused", existing.Type, existing.Name, existing.Content, existing.ID, nil)
+				continue
+			}
+			if desired.TTL == 0 {
+				desired.TTL = existing.TTL
+			}
+			if recordUpToDate(*existing, p.host, desired.TTL, *desired.Proxied) && p.opts.upToDate(*existing, desired.Comment) {
+				if s.Verbose {
+					log.Printf("unchanged dns record type %s, host %s, content %s, id %s", existing.Type, existing.Name, desired.Content, existing.ID)
+				}
+				rep.add(z.Name, "unchanged", existing.Type, existing.Name, existing.Content, existing.ID, nil)
+				managedRecords = append(managedRecords, newStateRecord(z.ID, *existing))
+				continue
+			}
+			desired.ID = existing.ID
+			action := "updated"
+			if s.Replace {
+				action = "replaced"
+			}
+			upserts = append(upserts, recordOp{action: action, record: desired, old: existing.Content})
+		} else if old, ok := s.legacyRecord(p.domain, p.host, currentByKey, matched); ok {
+			log.Printf("migrating dns record type %s, host %s to %s, id %s", old.Type, old.Name, desired.Name, old.ID)
+			matched[old.ID] = struct{}{}
+			desired.ID = old.ID
+			upserts = append(upserts, recordOp{action: "migrated", record: desired, old: old.Content})
+		} else {
+			upserts = append(upserts, recordOp{action: "created", record: desired})
+		}
+	}
+	if s.PruneOnly && len(upserts) > 0 {
+		log.Printf("prune: not applying %d record creates and updates in zone %s", len(upserts), z.Name)
+		upserts = nil
+	}
+
+	if s.MaxCreates > 0 {
+		var creates []recordOp
+		for _, op := range upserts {
+			if op.action == "created" {
+				creates = append(creates, op)
+			}
+		}
+		if len(creates) > s.MaxCreates {
+			for _, op := range creates {
+				log.Printf("would create dns record type %s, host %s, content %s", op.record.Type, op.record.Name, op.record.Content)
+			}
+			return nil, fmt.Errorf("zone %s: %d records to create exceeds max-creates %d", z.Name, len(creates), s.MaxCreates)
+		}
+	}
+
+	results, upsertErr := s.applyAll(ctx, z, upserts, rep)
+	for _, r := range results {
+		managedRecords = append(managedRecords, newStateRecord(z.ID, r))
+	}
+
+	if removeOrphans && len(hostList) > 0 {
+		managed := 0
+		for _, r := range currentRecords {
+			if z.Domains.Matches(r.Name) {
+				managed++
+			}
+		}
+		if managed < s.ProtectThreshold {
+			log.Printf("only %d managed records exist in zone %s but %d hosts are expected (first-run-protect %d), refusing to remove orphans", managed, z.Name, len(hostList), s.ProtectThreshold)
+			removeOrphans = false
+		}
+	}
+
+	// A host whose ip changed has its record updated above, never removed:
+	// only records no desired record claimed are orphan candidates. A failed
+	// change leaves its record claimed, so the cleanup still runs.
+	var cleanup []recordOp
+	for _, r := range currentRecords {
+		// Records of the previous state stay managed even when their name
+		// does not match the domains, e.g. a dotted alias under strict
+		// flatten matching.
+		if _, known := prev[r.ID]; !known && !z.Domains.Matches(r.Name) {
+			continue
+		}
+		if _, ok := matched[r.ID]; ok || z.protected(r) {
+			continue
+		}
+		if len(s.Catchall) > 0 && strings.HasPrefix(r.Name, "*.") && owned(s.InstanceID, r) {
+			// The catch-all outlives its host going offline.
+			managedRecords = append(managedRecords, newStateRecord(z.ID, r))
+			continue
+		}
+		// Only owned or adopted records are removed, whatever their type, so
+		// a foreign CNAME under the subdomain is left alone.
+		_, wanted := names[strings.ToLower(r.Name)]
+		_, off := disabled[strings.ToLower(r.Name)]
+		switch {
+		case off && !wanted && owned(s.InstanceID, r):
+			log.Printf("host of record with name %s, id %s is disabled in the config", r.Name, r.ID)
+			cleanup = append(cleanup, recordOp{action: "removed", record: r, old: r.Content})
+		case s.Replace && wanted && owned(s.InstanceID, r):
+			cleanup = append(cleanup, recordOp{action: "removed", record: r, old: r.Content})
+		case removeOrphans && (owned(s.InstanceID, r) || adoptable(s.Adopt, r)):
+			if p, ok := prev[r.ID]; ok && time.Since(p.SeenAt) < s.OrphanAfter {
+				log.Printf("keeping orphan record with name %s, ip %s, id %s, last wanted at %s", r.Name, r.Content, r.ID, p.SeenAt.Format(time.RFC3339))
+				p.ZoneID = z.ID
+				managedRecords = append(managedRecords, p)
+				continue
+			}
+			cleanup = append(cleanup, recordOp{action: "removed", record: r, old: r.Content})
+		case removeOrphans:
+			log.Printf("leaving unmanaged record with name %s, ip %s, id %s", r.Name, r.Content, r.ID)
+		case !owned(s.InstanceID, r) && adoptable(s.Adopt, r):
+			adopted := r
+			adopted.Comment = ownerComment(s.InstanceID, r.Comment)
+			cleanup = append(cleanup, recordOp{action: "adopted", record: adopted, old: r.Content})
+		}
+	}
+	slices.SortFunc(cleanup, func(a, b recordOp) int {
+		return compareRecords(a.record, b.record)
+	})
+	_, cleanupErr := s.applyAll(ctx, z, cleanup, rep)
+	if err := errors.Join(upsertErr, cleanupErr); err != nil {
+		return nil, err
+	}
+	return managedRecords, nil
+}
+
+// mergeRecent adds the records created by earlier passes that the zone
+// listing does not return yet, so a lagging listing does not cause duplicate
+// creates. Records the listing returns, or that are older than recentGrace,
+// are forgotten.
+func (s *Syncer) mergeRecent(zoneID string, listed []cloudflare.DNSRecord) []cloudflare.DNSRecord {
+	s.recentMu.Lock()
+	defer s.recentMu.Unlock()
+	if len(s.recent) == 0 {
+		return listed
+	}
+	ids := make(map[string]struct{}, len(listed))
+	for _, r := range listed {
+		ids[r.ID] = struct{}{}
+	}
+	for id, rr := range s.recent {
+		if rr.zoneID != zoneID {
+			continue
+		}
+		if _, ok := ids[id]; ok || time.Since(rr.created) > recentGrace {
+			delete(s.recent, id)
+			continue
+		}
+		log.Printf("record type %s, host %s, id %s not listed yet, using the created record", rr.record.Type, rr.record.Name, id)
+		listed = append(listed, rr.record)
+	}
+	return listed
+}
+
+// remember tracks the result of a create or delete for mergeRecent.
+func (s *Syncer) remember(zoneID, action string, r cloudflare.DNSRecord) {
+	s.recentMu.Lock()
+	defer s.recentMu.Unlock()
+	switch action {
+	case "created", "replaced":
+		if s.recent == nil {
+			s.recent = make(map[string]recentRecord)
+		}
+		s.recent[r.ID] = recentRecord{zoneID: zoneID, record: r, created: time.Now()}
+	case "removed":
+		delete(s.recent, r.ID)
+	}
+}
+
+// applyAll applies ops with up to -concurrency operations in flight, or in
+// batches with -batch. A failed operation does not stop the others; once ctx
+// is cancelled no new operations are started and the remaining ones are
+// reported as not attempted. It returns the records of the successful creates
+// and updates, and the first error noting how many operations failed.
+func (s *Syncer) applyAll(ctx context.Context, z Zone, ops []recordOp, rep *runReport) ([]cloudflare.DNSRecord, error) {
+	if s.DryRun {
+		return nil, s.dryRun(ops)
+	}
+	results := make([]cloudflare.DNSRecord, len(ops))
+	ok := make([]bool, len(ops))
+	attempted := make([]bool, len(ops))
+	var (
+		mu       sync.Mutex
+		firstErr error
+		failed   int
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed++
+	}
+	done := func(i int, r cloudflare.DNSRecord) {
+		action := ops[i].action
+		if action != "removed" && action != "created" && r.ID != ops[i].record.ID {
+			// A vanished record was created again.
+			action = "created"
+		}
+		s.remember(z.ID, action, r)
+		results[i], ok[i] = r, true
+	}
+	// Started operations finish even when ctx is cancelled, so no record is
+	// left half replaced.
+	opCtx := context.WithoutCancel(ctx)
+	individual := func(ops []recordOp, offset int) {
+		forEachLimit(ctx, s.Concurrency, len(ops), func(i int) error {
+			attempted[offset+i] = true
+			r, err := s.apply(opCtx, z, ops[i])
+			rep.add(z.Name, ops[i].action, ops[i].record.Type, ops[i].record.Name, ops[i].record.Content, r.ID, err)
+			if err != nil {
+				fail(err)
+				return nil
+			}
+			s.auditOp(z.Name, ops[i], r.ID)
+			done(offset+i, r)
+			return nil
+		})
+	}
+
+	if !s.Batch {
+		individual(ops, 0)
+	}
+	for start := 0; s.Batch && start < len(ops) && ctx.Err() == nil; start += maxBatchSize {
+		chunk := ops[start:min(start+maxBatchSize, len(ops))]
+		res, berr := s.applyBatch(opCtx, z, chunk)
+		if berr != nil {
+			log.Printf("batch of %d changes failed, applying them one by one: %v", len(chunk), berr)
+			individual(chunk, start)
+			continue
+		}
+		for i, r := range res {
+			attempted[start+i] = true
+			rep.add(z.Name, chunk[i].action, chunk[i].record.Type, chunk[i].record.Name, chunk[i].record.Content, r.ID, nil)
+			s.auditOp(z.Name, chunk[i], r.ID)
+			done(start+i, r)
+		}
+	}
+
+	skipped := 0
+	for i, op := range ops {
+		if !attempted[i] {
+			skipped++
+			rep.add(z.Name, op.action, op.record.Type, op.record.Name, op.record.Content, op.record.ID, fmt.Errorf("not attempted: %w", ctx.Err()))
+		}
+	}
+	var applied []cloudflare.DNSRecord
+	for i, op := range ops {
+		if ok[i] && (op.action == "created" || op.action == "updated" || op.action == "replaced" || op.action == "migrated") {
+			applied = append(applied, results[i])
+		}
+	}
+	var err error
+	switch {
+	case skipped > 0:
+		err = fmt.Errorf("%d record changes not attempted: %w", skipped, ctx.Err())
+	case failed == 1:
+		err = firstErr
+	case failed > 1:
+		err = fmt.Errorf("%d record changes failed, the first: %w", failed, firstErr)
+	}
+	return applied, err
+}
+
+// dryRun logs ops without applying them and reports planned records that
+// cloudflare would reject.
+func (s *Syncer) dryRun(ops []recordOp) error {
+	invalid := 0
+	for _, op := range ops {
+		r := op.record
+		log.Printf("dry run: would have %s dns record type %s, host %s, content %s", op.action, r.Type, r.Name, r.Content)
+		if op.action == "removed" || op.action == "adopted" {
+			continue
+		}
+		problems := validateRecord(r)
+		for _, p := range problems {
+			log.Printf("dry run: invalid record type %s, host %s: %s", r.Type, r.Name, p)
+		}
+		if len(problems) > 0 {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("dry run: %d planned records would be rejected by cloudflare", invalid)
+	}
+	return nil
+}
+
+// apply performs a single planned change.
+func (s *Syncer) apply(ctx context.Context, z Zone, op recordOp) (cloudflare.DNSRecord, error) {
+	rc := cloudflare.ZoneIdentifier(z.ID)
+	r := op.record
+	var result cloudflare.DNSRecord
+	var err error
+	switch op.action {
+	case "created":
+		result, err = s.API.CreateDNSRecord(ctx, rc, createParams(r))
+	case "replaced":
+		// Cloudflare rejects a record identical to the old one, e.g. when only
+		// the ttl changed, and a cname next to another record of its name, so
+		// the old record is removed first. An address record whose address
+		// changed is created first to avoid a gap.
+		createFirst := r.Type != "CNAME" && !sameAddress(r.Content, op.old)
+		if !createFirst {
+			if err := s.API.DeleteDNSRecord(ctx, rc, r.ID); err != nil && !isNotFound(err) {
+				return cloudflare.DNSRecord{}, fmt.Errorf("unable to remove replaced record %s: %w", r.ID, err)
+			}
+		}
+		result, err = s.API.CreateDNSRecord(ctx, rc, createParams(r))
+		if err == nil && createFirst {
+			if err := s.API.DeleteDNSRecord(ctx, rc, r.ID); err != nil && !isNotFound(err) {
+				return result, fmt.Errorf("unable to remove replaced record %s: %w", r.ID, err)
+			}
+		}
+	case "updated", "adopted", "migrated":
+		result, err = s.API.UpdateDNSRecord(ctx, rc, cloudflare.UpdateDNSRecordParams{
+			ID:       r.ID,
+			Type:     r.Type,
+			Name:     r.Name,
+			Content:  r.Content,
+			TTL:      r.TTL,
+			Proxied:  r.Proxied,
+			Comment:  &r.Comment,
+			Tags:     r.Tags,
+			Priority: r.Priority,
+			Settings: r.Settings,
+			Data:     r.Data,
+		})
+		// The record was deleted since it was listed.
+		if isNotFound(err) {
+			if op.action == "adopted" {
+				log.Printf("record with name %s, id %s to adopt no longer exists", r.Name, r.ID)
+				return r, nil
+			}
+			log.Printf("dns record type %s, host %s, id %s no longer exists, creating it", r.Type, r.Name, r.ID)
+			result, err = s.API.CreateDNSRecord(ctx, rc, createParams(r))
+		}
+	case "removed":
+		log.Printf("removing record with name %s, ip %s, id %s", r.Name, r.Content, r.ID)
+		if err := s.API.DeleteDNSRecord(ctx, rc, r.ID); isNotFound(err) {
+			log.Printf("record with name %s, id %s was already removed", r.Name, r.ID)
+		} else if err != nil {
+			return cloudflare.DNSRecord{}, err
+		}
+		return r, nil
+	default:
+		return cloudflare.DNSRecord{}, fmt.Errorf("unknown record action %q", op.action)
+	}
+	if err != nil {
+		proxied := r.Proxied != nil && *r.Proxied
+		verb := map[string]string{"created": "create", "updated": "update", "adopted": "adopt", "replaced": "replace", "migrated": "migrate"}[op.action]
+		return result, fmt.Errorf("unable to %s dns record type %s, host %s, content %s, ttl %d, proxied %t. err: %w",
+			verb, r.Type, r.Name, r.Content, r.TTL, proxied, err)
+	}
+	log.Printf("%s dns record type %s, host %s, content %s, id %s", op.action, r.Type, r.Name, r.Content, result.ID)
+	return result, nil
+}
+
+// sameAddress reports whether a and b are the same ip, whatever their format.
+func sameAddress(a, b string) bool {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ipA == ipB
+}
+
+// isNotFound reports whether err is cloudflare's answer for a missing record.
+func isNotFound(err error) bool {
+	var notFound *cloudflare.NotFoundError
+	return errors.As(err, &notFound)
+}
+
+func createParams(r cloudflare.DNSRecord) cloudflare.CreateDNSRecordParams {
+	return cloudflare.CreateDNSRecordParams{
+		Type:     r.Type,
+		Name:     r.Name,
+		Content:  r.Content,
+		TTL:      r.TTL,
+		Proxied:  r.Proxied,
+		Comment:  r.Comment,
+		Tags:     r.Tags,
+		Priority: r.Priority,
+		Settings: r.Settings,
+		Data:     r.Data,
+	}
+}
+
+// forEachLimit calls fn for every index below count with at most limit calls
+// running at once. A failed call does not stop the others, but once ctx is
+// cancelled no new calls are started. After the running calls finish it
+// returns ctx's error if calls were left out, otherwise the errors of the
+// failed calls joined in index order.
+func forEachLimit(ctx context.Context, limit, count int, fn func(i int) error) error {
+	if limit < 1 {
+		limit = 1
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, count)
+	var cancelled error
+	sem := make(chan struct{}, limit)
+	for i := 0; i < count; i++ {
+		sem <- struct{}{}
+		if err := ctx.Err(); err != nil {
+			<-sem
+			cancelled = err
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+	if cancelled != nil {
+		return cancelled
+	}
+	return errors.Join(errs...)
+}
+
+// SortRecords orders records by name, type and content.
+func SortRecords(records []cloudflare.DNSRecord) {
+	slices.SortFunc(records, compareRecords)
+}
+
+func compareRecords(a, b cloudflare.DNSRecord) int {
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Type, b.Type); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Content, b.Content)
+}
+
+// RemoveAll removes every record under the Syncer's domains that carries
+// this instance's owner marker or matches Adopt. With DryRun it only logs
+// them.
+func (s *Syncer) RemoveAll(ctx context.Context) error {
+	if err := s.checkSelfTag(ctx); err != nil {
+		return err
+	}
+	for _, z := range s.Zones {
+		currentRecords, err := ListZoneRecords(ctx, s.API, z, s.PerPage)
+		if err != nil {
+			return err
+		}
+		SortRecords(currentRecords)
+		for _, r := range currentRecords {
+			// Records of other instances and hand made ones stay, whatever
+			// their type.
+			ours := owned(s.InstanceID, r) || adoptable(s.Adopt, r)
+			if ours && z.Domains.Matches(r.Name) && !z.protected(r) {
+				if s.DryRun {
+					log.Printf("dry run: would have removed dns record type %s, host %s, content %s", r.Type, r.Name, r.Content)
+					continue
+				}
+				log.Printf("removing record with name %s, ip %s, id %s", r.Name, r.Content, r.ID)
+				if err := s.API.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(z.ID), r.ID); isNotFound(err) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				s.audit(z.Name, "removed", r.Type, r.Name, r.Content, "", r.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Watch runs a sync pass every interval until ctx is done. A pass that is due
+// while the previous one is still running is skipped. When ctx is done the
+// running pass starts no new record operations and Watch returns once the
+// started ones finish.
+func (s *Syncer) Watch(ctx context.Context, interval time.Duration) {
+	var running sync.Mutex
+	// done receives the result of each pass, for WatchErrorInterval.
+	done := make(chan error, 1)
+	pass := func() {
+		if !running.TryLock() {
+			log.Print("previous sync still running, skipping")
+			return
+		}
+		go func() {
+			defer running.Unlock()
+			err := s.RunOnce(ctx)
+			if err != nil {
+				log.Printf("sync failed: %v", err)
+			}
+			select {
+			case done <- err:
+			default:
+			}
+		}()
+	}
+
+	wait := func() time.Duration {
+		if s.WatchJitter <= 0 {
+			return interval
+		}
+		return interval + rand.N(s.WatchJitter)
+	}
+
+	pass()
+	timer := time.NewTimer(wait())
+	defer timer.Stop()
+	var retry time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			// Let the running pass finish its started operations.
+			running.Lock()
+			log.Print("stopped")
+			return
+		case <-timer.C:
+			pass()
+			timer.Reset(wait())
+		case err := <-done:
+			if err == nil || s.WatchErrorInterval <= 0 || ctx.Err() != nil {
+				retry = 0
+				continue
+			}
+			retry = min(max(retry*2, s.WatchErrorInterval), interval)
+			log.Printf("retrying in %s", retry)
+			timer.Reset(retry)
+		}
+	}
+}
+
+// DeleteByID deletes the records with the given ids, looking each one up in
+// the Syncer's zones. Unlike a sync it does not check whether the records are
+// managed. With DryRun it only logs them.
+func (s *Syncer) DeleteByID(ctx context.Context, ids []string) error {
+	if err := s.checkSelfTag(ctx); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		found := false
+		for _, z := range s.Zones {
+			r, err := s.API.GetDNSRecord(ctx, cloudflare.ZoneIdentifier(z.ID), id)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("unable to look up record %s in zone %s: %w", id, z.Name, err)
+			}
+			found = true
+			if s.DryRun {
+				log.Printf("dry run: would have deleted dns record type %s, host %s, content %s, id %s", r.Type, r.Name, r.Content, r.ID)
+				break
+			}
+			log.Printf("removing record with name %s, ip %s, id %s", r.Name, r.Content, r.ID)
+			if err := s.API.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(z.ID), id); err != nil {
+				return err
+			}
+			s.audit(z.Name, "removed", r.Type, r.Name, r.Content, "", r.ID)
+			break
+		}
+		if !found {
+			return fmt.Errorf("record %s not found in any zone", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,630 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// staticSource returns the same nodes on every call.
+type staticSource []Node
+
+func (s staticSource) Nodes(context.Context) ([]Node, error) {
+	return s, nil
+}
+
+// testZone holds records under wg.example.com.
+var testZone = Zone{ID: "zone1", Name: "example.com", Domains: DomainList{{Domain: "example.com", Sub: "wg", Strict: true}}}
+
+// peer returns an online node tagged tag:dns.
+func peer(name string, ips ...string) Node {
+	n := Node{HostName: name, Tags: []string{"tag:dns"}, Online: true}
+	for _, ip := range ips {
+		n.IPs = append(n.IPs, netip.MustParseAddr(ip))
+	}
+	return n
+}
+
+func testSyncer(api DNSClient, nodes ...Node) *Syncer {
+	return &Syncer{
+		API:     api,
+		Source:  staticSource(nodes),
+		Zones:   []Zone{testZone},
+		Tag:     "tag:dns",
+		TTL:     DefaultTTL,
+		PerPage: 100,
+	}
+}
+
+func runOnce(t *testing.T, s *Syncer) {
+	t.Helper()
+	if err := s.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+}
+
+func TestRunOnceIdempotent(t *testing.T) {
+	api := newFakeDNS()
+	nodes := []Node{
+		peer("a", "100.64.0.5", "fd7a:115c:a1e0::5"),
+		peer("b", "100.64.0.6", "100.64.0.7"),
+	}
+	state := filepath.Join(t.TempDir(), "state.json")
+	configure := func(s *Syncer) {
+		s.RemoveOrphans = true
+		s.StateFile = state
+		s.Aliases = map[string][]string{"a": {"web"}}
+		s.Overrides = map[string][]Host{"b": {{Name: "b", Type: "CNAME", Target: "a.wg.example.com"}}}
+		s.HostsTXT = "_hosts"
+	}
+	first := testSyncer(api, nodes...)
+	configure(first)
+	runOnce(t, first)
+	if n := api.count("create"); n != 6 {
+		t.Fatalf("first run made %d creates, want 6: %v", n, api.reset())
+	}
+	api.reset()
+
+	// The same Syncer, which remembers its creates, and a new one, which only
+	// sees the listing, both find nothing to do.
+	second := testSyncer(api, nodes...)
+	configure(second)
+	for _, s := range []*Syncer{first, second} {
+		runOnce(t, s)
+		if calls := api.reset(); len(calls) > 0 {
+			t.Errorf("second run made changes: %v", calls)
+		}
+	}
+}
+
+func TestReplaceOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing cloudflare.DNSRecord
+		host     Node
+		override map[string][]Host
+		want     []string
+	}{
+		{
+			name:     "ttl only",
+			existing: cloudflare.DNSRecord{ID: "old", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.5", TTL: 3600, Comment: ownerMarker},
+			host:     peer("a", "100.64.0.5"),
+			want:     []string{"delete old", "create A a.wg.example.com 100.64.0.5"},
+		},
+		{
+			name:     "expanded ipv6 is the same address",
+			existing: cloudflare.DNSRecord{ID: "old", Type: "AAAA", Name: "a.wg.example.com", Content: "fd7a:115c:a1e0:0000:0000:0000:0000:0005", TTL: 3600, Comment: ownerMarker},
+			host:     peer("a", "fd7a:115c:a1e0::5"),
+			want:     []string{"delete old", "create AAAA a.wg.example.com fd7a:115c:a1e0::5"},
+		},
+		{
+			name:     "new address",
+			existing: cloudflare.DNSRecord{ID: "old", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: DefaultTTL, Comment: ownerMarker},
+			host:     peer("a", "100.64.0.5"),
+			want:     []string{"create A a.wg.example.com 100.64.0.5", "delete old"},
+		},
+		{
+			name:     "cname",
+			existing: cloudflare.DNSRecord{ID: "old", Type: "CNAME", Name: "a.wg.example.com", Content: "old.example.net", TTL: DefaultTTL, Comment: ownerMarker},
+			host:     peer("a", "100.64.0.5"),
+			override: map[string][]Host{"a": {{Name: "a", Type: "CNAME", Target: "new.example.net"}}},
+			want:     []string{"delete old", "create CNAME a.wg.example.com new.example.net"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNS(tt.existing)
+			s := testSyncer(api, tt.host)
+			s.Replace = true
+			s.Overrides = tt.override
+			runOnce(t, s)
+			if calls := api.reset(); !slices.Equal(calls, tt.want) {
+				t.Errorf("calls = %v, want %v", calls, tt.want)
+			}
+			if records := api.byName("a.wg.example.com"); len(records) != 1 {
+				t.Errorf("records after replace = %+v, want one", records)
+			}
+		})
+	}
+}
+
+func TestRemoveAllDryRun(t *testing.T) {
+	api := newFakeDNS(cloudflare.DNSRecord{ID: "a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.5", Comment: ownerMarker})
+	s := testSyncer(api)
+	s.DryRun = true
+	if err := s.RemoveAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.reset(); len(calls) > 0 {
+		t.Errorf("dry run made changes: %v", calls)
+	}
+}
+
+func TestApplyKeepsGoingAfterFailures(t *testing.T) {
+	api := newFakeDNS(cloudflare.DNSRecord{ID: "gone", Type: "A", Name: "gone.wg.example.com", Content: "100.64.0.9", Comment: ownerMarker})
+	api.fail["a.wg.example.com"] = errors.New("boom")
+	api.fail["c.wg.example.com"] = errors.New("boom")
+	s := testSyncer(api, peer("a", "100.64.0.5"), peer("b", "100.64.0.6"), peer("c", "100.64.0.7"), peer("d", "100.64.0.8"))
+	s.Concurrency = 1
+	s.RemoveOrphans = true
+	s.ReportFile = filepath.Join(t.TempDir(), "report.json")
+	if err := s.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded, want an error")
+	}
+	if n := api.count("create"); n != 4 {
+		t.Errorf("got %d creates, want all 4 attempted", n)
+	}
+	if n := api.count("delete gone"); n != 1 {
+		t.Errorf("orphan deleted %d times, want once", n)
+	}
+
+	b, err := os.ReadFile(s.ReportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep runReport
+	if err := json.Unmarshal(b, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Counts["failed"] != 2 || rep.Counts["created"] != 2 {
+		t.Errorf("report counts = %v, want 2 failed and 2 created", rep.Counts)
+	}
+	var failed []string
+	for _, a := range rep.Actions {
+		if len(a.Error) > 0 {
+			failed = append(failed, a.Name)
+		}
+	}
+	slices.Sort(failed)
+	if want := []string{"a.wg.example.com", "c.wg.example.com"}; !slices.Equal(failed, want) {
+		t.Errorf("failed records = %v, want %v", failed, want)
+	}
+}
+
+func TestForEachLimitCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := forEachLimit(ctx, 1, 5, func(i int) error {
+		calls++
+		if i == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || calls != 2 {
+		t.Errorf("got %d calls and error %v, want 2 calls and context.Canceled", calls, err)
+	}
+}
+
+func TestSkipReasonAlwaysInclude(t *testing.T) {
+	untagged := func(n Node) Node {
+		n.Tags = nil
+		return n
+	}
+	expired := func(n Node) Node {
+		n.KeyExpiry = time.Now().Add(-time.Hour)
+		return n
+	}
+	offline := func(n Node) Node {
+		n.Online = false
+		n.LastSeen = time.Now().Add(-time.Minute)
+		return n
+	}
+	self := func(n Node) Node {
+		n.Self = true
+		return n
+	}
+	tests := []struct {
+		name      string
+		node      Node
+		configure func(*Syncer)
+		want      string
+	}{
+		{"untagged", untagged(peer("nas")), nil, ""},
+		{"offline", offline(untagged(peer("nas"))), nil, "offline"},
+		{"recently seen", offline(untagged(peer("nas"))), func(s *Syncer) { s.OrphanAfter = time.Hour }, ""},
+		{"expired", expired(untagged(peer("nas"))), func(s *Syncer) { s.ExcludeExpired = true }, reasonExpired},
+		{"skip self", self(untagged(peer("nas"))), func(s *Syncer) { s.SkipSelf = true }, "local node, skip-self"},
+		{"offline self", offline(self(untagged(peer("me")))), nil, ""},
+		{"other os", func() Node { n := untagged(peer("nas")); n.OS = "windows"; return n }(), func(s *Syncer) { s.OSFilter = []string{"linux"} }, ""},
+		{"not listed", untagged(peer("other")), nil, "not tagged tag:dns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSyncer(newFakeDNS())
+			s.AlwaysInclude = []string{"NAS"}
+			if tt.configure != nil {
+				tt.configure(s)
+			}
+			if got := s.skipReason(tt.node); got != tt.want {
+				t.Errorf("skipReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlwaysIncludeOfflineThreshold(t *testing.T) {
+	api := newFakeDNS()
+	n := peer("nas", "100.64.0.5")
+	n.Tags = nil
+	s := testSyncer(api)
+	s.AlwaysInclude = []string{"nas"}
+	s.RemoveOrphans = true
+	s.OfflineThreshold = 2
+	for i, online := range []bool{true, false, false} {
+		n.Online = online
+		s.Source = staticSource{n}
+		runOnce(t, s)
+		want := 1
+		if i == 2 {
+			want = 0
+		}
+		if got := len(api.byName("nas.wg.example.com")); got != want {
+			t.Errorf("pass %d: got %d records, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestRunOnceTwoSubdomains(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "gone-wg", Type: "A", Name: "gone.wg.example.com", Content: "100.64.0.9", Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "gone-vpn", Type: "A", Name: "gone.vpn.example.com", Content: "100.64.0.9", Comment: ownerMarker},
+	)
+	s := testSyncer(api, peer("a", "100.64.0.5"))
+	s.Zones = []Zone{{ID: "zone1", Name: "example.com", Domains: DomainList{
+		{Domain: "example.com", Sub: "wg", Strict: true},
+		{Domain: "example.com", Sub: "vpn", Strict: true},
+	}}}
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	for _, name := range []string{"a.wg.example.com", "a.vpn.example.com"} {
+		if records := api.byName(name); len(records) != 1 {
+			t.Errorf("records of %s = %+v, want one", name, records)
+		}
+	}
+	if n := api.count("delete gone-wg") + api.count("delete gone-vpn"); n != 2 {
+		t.Errorf("removed %d orphans, want the one under each subdomain", n)
+	}
+	api.reset()
+	runOnce(t, s)
+	if calls := api.reset(); len(calls) > 0 {
+		t.Errorf("second run made changes: %v", calls)
+	}
+}
+
+func TestRunOnceMalformedContent(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "bad-a", Type: "A", Name: "a.wg.example.com", Content: "not-an-ip", Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "bad-aaaa", Type: "AAAA", Name: "junk.wg.example.com", Content: "fd7a::zz", Comment: ownerMarker},
+	)
+	s := testSyncer(api, peer("a", "100.64.0.5"))
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	if records := api.byName("a.wg.example.com"); len(records) != 1 || records[0].Content != "100.64.0.5" {
+		t.Errorf("records of a = %+v, want one for 100.64.0.5", records)
+	}
+	if records := api.byName("junk.wg.example.com"); len(records) > 0 {
+		t.Errorf("orphan with malformed content kept: %+v", records)
+	}
+	api.reset()
+	runOnce(t, s)
+	if calls := api.reset(); len(calls) > 0 {
+		t.Errorf("second run made changes: %v", calls)
+	}
+}
+
+func TestSortHostsStable(t *testing.T) {
+	hosts := []Host{
+		{Name: "b", IP: netip.MustParseAddr("100.64.0.2")},
+		{Name: "a", IP: netip.MustParseAddr("fd7a:115c:a1e0::1")},
+		{Name: "a", IP: netip.MustParseAddr("100.64.0.9")},
+		{Name: "a", IP: netip.MustParseAddr("100.64.0.1")},
+		{Name: "c", Type: "CNAME", Target: "x.example.net"},
+		{Name: "a", Segment: "db", IP: netip.MustParseAddr("100.64.0.1")},
+	}
+	want := []string{"a 100.64.0.1", "a 100.64.0.9", "a fd7a:115c:a1e0::1", "a db 100.64.0.1", "b 100.64.0.2", "c x.example.net"}
+	key := func(h Host) string {
+		k := h.Name
+		if len(h.Segment) > 0 {
+			k += " " + h.Segment
+		}
+		if len(h.Target) > 0 {
+			return k + " " + h.Target
+		}
+		return k + " " + h.IP.String()
+	}
+	for i := 0; i < 10; i++ {
+		shuffled := slices.Clone(hosts)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		sortHosts(shuffled)
+		var got []string
+		for _, h := range shuffled {
+			got = append(got, key(h))
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("sorted hosts = %v, want %v", got, want)
+		}
+	}
+
+	limited, truncated := limitHosts(slices.Clone(hosts), 2)
+	if !truncated || len(limited) != 5 || limited[len(limited)-1].Name != "b" {
+		t.Errorf("limitHosts(2) = %+v, %v, want the addresses of a and b", limited, truncated)
+	}
+}
+
+func TestRunOnceStableOrder(t *testing.T) {
+	nodes := []Node{peer("c", "100.64.0.3"), peer("a", "100.64.0.1"), peer("b", "100.64.0.2")}
+	var first []string
+	for i := 0; i < 5; i++ {
+		rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+		api := newFakeDNS()
+		runOnce(t, testSyncer(api, nodes...))
+		calls := api.reset()
+		if i == 0 {
+			first = calls
+		} else if !slices.Equal(calls, first) {
+			t.Fatalf("calls = %v, want %v", calls, first)
+		}
+	}
+	if want := "create A a.wg.example.com 100.64.0.1"; first[0] != want {
+		t.Errorf("first call = %q, want %q", first[0], want)
+	}
+}
+
+func TestRunOnceAddressChangeIsUpdate(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "a4", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: DefaultTTL, Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "a6", Type: "AAAA", Name: "a.wg.example.com", Content: "fd7a:115c:a1e0::9", TTL: DefaultTTL, Comment: ownerMarker},
+	)
+	s := testSyncer(api, peer("a", "100.64.0.5", "fd7a:115c:a1e0::5"))
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	calls := api.reset()
+	slices.Sort(calls)
+	if want := []string{"update a4", "update a6"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	records := api.byName("a.wg.example.com")
+	if len(records) != 2 || records[0].Content != "100.64.0.5" || records[1].Content != "fd7a:115c:a1e0::5" {
+		t.Errorf("records = %+v, want the new addresses", records)
+	}
+}
+
+func TestRunOnceTwoAddressesOneHost(t *testing.T) {
+	api := newFakeDNS(cloudflare.DNSRecord{ID: "old", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: DefaultTTL, Comment: ownerMarker})
+	s := testSyncer(api, peer("a", "100.64.0.5", "100.64.0.6"))
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	contents := func() []string {
+		var c []string
+		for _, r := range api.byName("a.wg.example.com") {
+			c = append(c, r.Content)
+		}
+		return c
+	}
+	if got, want := contents(), []string{"100.64.0.5", "100.64.0.6"}; !slices.Equal(got, want) {
+		t.Errorf("contents = %v, want %v", got, want)
+	}
+	api.reset()
+	runOnce(t, s)
+	if calls := api.reset(); len(calls) > 0 {
+		t.Errorf("second run made changes: %v", calls)
+	}
+
+	// Dropping one address removes only its record.
+	s.Source = staticSource{peer("a", "100.64.0.6")}
+	runOnce(t, s)
+	if got, want := contents(), []string{"100.64.0.6"}; !slices.Equal(got, want) {
+		t.Errorf("contents after dropping an address = %v, want %v", got, want)
+	}
+	if calls := api.reset(); len(calls) != 1 || !strings.HasPrefix(calls[0], "delete ") {
+		t.Errorf("calls = %v, want a single delete", calls)
+	}
+}
+
+func TestRunOnceTTLZeroKeepsTTL(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: 3600, Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "b", Type: "A", Name: "b.wg.example.com", Content: "100.64.0.6", TTL: 120, Comment: ownerMarker},
+	)
+	s := testSyncer(api, peer("a", "100.64.0.5"), peer("b", "100.64.0.6"), peer("c", "100.64.0.7"))
+	s.TTL = 0
+	runOnce(t, s)
+	calls := api.reset()
+	slices.Sort(calls)
+	if want := []string{"create A c.wg.example.com 100.64.0.7", "update a"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want c created and only a updated", calls)
+	}
+	a := api.byName("a.wg.example.com")[0]
+	if a.Content != "100.64.0.5" || a.TTL != 3600 {
+		t.Errorf("a = %s ttl %d, want 100.64.0.5 with its ttl 3600 kept", a.Content, a.TTL)
+	}
+	if c := api.byName("c.wg.example.com")[0]; c.TTL != 1 {
+		t.Errorf("c ttl = %d, want the cloudflare default 1", c.TTL)
+	}
+}
+
+func TestCNAMEOwnership(t *testing.T) {
+	records := []cloudflare.DNSRecord{
+		{ID: "owned", Type: "CNAME", Name: "old.wg.example.com", Content: "a.wg.example.com", Comment: ownerMarker},
+		{ID: "foreign", Type: "CNAME", Name: "alias.wg.example.com", Content: "a.wg.example.com", Comment: "made by hand"},
+	}
+	tests := []struct {
+		name string
+		run  func(s *Syncer) error
+	}{
+		{"remove all", func(s *Syncer) error { return s.RemoveAll(context.Background()) }},
+		{"remove orphans", func(s *Syncer) error {
+			s.RemoveOrphans = true
+			return s.RunOnce(context.Background())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNS(records...)
+			s := testSyncer(api)
+			if err := tt.run(s); err != nil {
+				t.Fatal(err)
+			}
+			if calls := api.reset(); !slices.Equal(calls, []string{"delete owned"}) {
+				t.Errorf("calls = %v, want only the owned cname deleted", calls)
+			}
+		})
+	}
+
+	// A CNAME override is tracked like any record and swept once it goes.
+	api := newFakeDNS()
+	s := testSyncer(api, peer("a", "100.64.0.5"))
+	s.RemoveOrphans = true
+	s.Overrides = map[string][]Host{"a": {{Name: "a", Type: "CNAME", Target: "target.example.net"}}}
+	runOnce(t, s)
+	if records := api.byName("a.wg.example.com"); len(records) != 1 || records[0].Type != "CNAME" {
+		t.Fatalf("records = %+v, want the cname override", records)
+	}
+	s.Overrides = nil
+	runOnce(t, s)
+	if records := api.byName("a.wg.example.com"); len(records) != 1 || records[0].Type != "A" {
+		t.Errorf("records = %+v, want the cname replaced by the host's A record", records)
+	}
+}
+
+func TestRunOnceRecordGoneMidRun(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "stale-a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: DefaultTTL, Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "stale-orphan", Type: "A", Name: "old.wg.example.com", Content: "100.64.0.8", TTL: DefaultTTL, Comment: ownerMarker},
+	)
+	api.gone["stale-a"] = true
+	api.gone["stale-orphan"] = true
+	s := testSyncer(api, peer("a", "100.64.0.5"))
+	s.RemoveOrphans = true
+	runOnce(t, s)
+	calls := api.reset()
+	slices.Sort(calls)
+	if want := []string{"create A a.wg.example.com 100.64.0.5", "delete stale-orphan", "update stale-a"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRemoveAllOwnedOnly(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "ours", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.5", Comment: instanceMarker("home")},
+		cloudflare.DNSRecord{ID: "other-instance", Type: "A", Name: "b.wg.example.com", Content: "100.64.0.6", Comment: instanceMarker("office")},
+		cloudflare.DNSRecord{ID: "default-instance", Type: "AAAA", Name: "c.wg.example.com", Content: "fd7a:115c:a1e0::7", Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "hand-made", Type: "A", Name: "d.wg.example.com", Content: "100.64.0.8"},
+		cloudflare.DNSRecord{ID: "legacy", Type: "A", Name: "legacy-e.wg.example.com", Content: "100.64.0.9"},
+	)
+	s := testSyncer(api)
+	s.InstanceID = "home"
+	s.Adopt = regexp.MustCompile(`^legacy-`)
+	if err := s.RemoveAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	calls := api.reset()
+	slices.Sort(calls)
+	if want := []string{"delete legacy", "delete ours"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestDeleteByIDDryRun(t *testing.T) {
+	api := newFakeDNS(cloudflare.DNSRecord{ID: "a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.5"})
+	s := testSyncer(api)
+	s.DryRun = true
+	if err := s.DeleteByID(context.Background(), []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.reset(); len(calls) > 0 {
+		t.Errorf("dry run made changes: %v", calls)
+	}
+	if err := s.DeleteByID(context.Background(), []string{"missing"}); err == nil {
+		t.Error("dry run of a missing id succeeded, want an error")
+	}
+
+	s.DryRun = false
+	if err := s.DeleteByID(context.Background(), []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.reset(); !slices.Equal(calls, []string{"delete a"}) {
+		t.Errorf("calls = %v, want delete a", calls)
+	}
+}
+
+func TestOSFilter(t *testing.T) {
+	withOS := func(n Node, os string) Node {
+		n.OS = os
+		return n
+	}
+	// Tagged peers with a selected os are published; -tag still leaves out
+	// the untagged one.
+	untagged := withOS(peer("untagged-linux", "100.64.0.5"), "linux")
+	untagged.Tags = nil
+	nodes := []Node{
+		withOS(peer("nas", "100.64.0.1"), "linux"),
+		withOS(peer("desktop", "100.64.0.2"), "windows"),
+		withOS(peer("phone", "100.64.0.3"), "iOS"),
+		withOS(peer("mac", "100.64.0.4"), "macOS"),
+		untagged,
+	}
+	tests := []struct {
+		name string
+		os   []string
+		want []string
+	}{
+		{"no filter", nil, []string{"desktop", "mac", "nas", "phone"}},
+		{"one", []string{"linux"}, []string{"nas"}},
+		{"case insensitive", []string{"LINUX", "ios"}, []string{"nas", "phone"}},
+		{"several", []string{"linux", "windows", "ios"}, []string{"desktop", "nas", "phone"}},
+		{"none match", []string{"android"}, nil},
+	}
+	s := testSyncer(newFakeDNS())
+	s.OSFilter = []string{"linux"}
+	if reason := s.skipReason(untagged); reason != "not tagged tag:dns" {
+		t.Errorf("skipReason(untagged linux) = %q, want not tagged", reason)
+	}
+	if reason := s.skipReason(nodes[1]); reason != "os windows not selected" {
+		t.Errorf("skipReason(windows) = %q, want os not selected", reason)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testSyncer(newFakeDNS(), nodes...)
+			s.OSFilter = tt.os
+			hosts, _, err := s.Hosts(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range hosts {
+				got = append(got, h.Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("hosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunOncePruneOnly(t *testing.T) {
+	api := newFakeDNS(
+		cloudflare.DNSRecord{ID: "a", Type: "A", Name: "a.wg.example.com", Content: "100.64.0.9", TTL: DefaultTTL, Comment: ownerMarker},
+		cloudflare.DNSRecord{ID: "gone", Type: "A", Name: "gone.wg.example.com", Content: "100.64.0.8", TTL: DefaultTTL, Comment: ownerMarker},
+	)
+	s := testSyncer(api, peer("a", "100.64.0.5"), peer("b", "100.64.0.6"))
+	s.RemoveOrphans = true
+	s.PruneOnly = true
+	runOnce(t, s)
+	if calls := api.reset(); !slices.Equal(calls, []string{"delete gone"}) {
+		t.Errorf("calls = %v, want only the orphan deleted", calls)
+	}
+}
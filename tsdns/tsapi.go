@@ -0,0 +1,251 @@
+package tsdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTailscaleAPI is the base url of the tailscale http api.
+const DefaultTailscaleAPI = "https://api.tailscale.com"
+
+// APISource lists nodes from the tailscale http api. It authenticates with
+// either an api key or oauth client credentials, which take precedence when
+// ClientID is set.
+type APISource struct {
+	BaseURL string
+	// Tailnet is the tailnet name, "-" for the credentials' default.
+	Tailnet string
+	Client  *http.Client
+
+	APIKey       string
+	ClientID     string
+	ClientSecret string
+
+	// Attrs fetches the posture attributes of every device, one request per
+	// device.
+	Attrs bool
+	// Groups fetches the tailnet policy file to find the groups of every
+	// device's owner.
+	Groups bool
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type apiDevice struct {
+	NodeID             string   `json:"nodeId"`
+	Addresses          []string `json:"addresses"`
+	Name               string   `json:"name"`
+	Hostname           string   `json:"hostname"`
+	User               string   `json:"user"`
+	OS                 string   `json:"os"`
+	Tags               []string `json:"tags"`
+	ConnectedToControl bool     `json:"connectedToControl"`
+	KeyExpiryDisabled  bool     `json:"keyExpiryDisabled"`
+	Expires            string   `json:"expires"`
+	LastSeen           string   `json:"lastSeen"`
+}
+
+// Nodes lists the devices of the tailnet.
+func (s *APISource) Nodes(ctx context.Context) ([]Node, error) {
+	var resp struct {
+		Devices []apiDevice `json:"devices"`
+	}
+	path := "/api/v2/tailnet/" + url.PathEscape(s.Tailnet) + "/devices"
+	if err := s.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	var members map[string][]string
+	if s.Groups {
+		m, err := s.groupMembers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		members = m
+	}
+	nodes := make([]Node, 0, len(resp.Devices))
+	for _, d := range resp.Devices {
+		n := Node{
+			HostName: d.Hostname,
+			DNSName:  strings.TrimSuffix(d.Name, "."),
+			Tags:     d.Tags,
+			OS:       d.OS,
+			Online:   d.ConnectedToControl,
+		}
+		if !d.KeyExpiryDisabled && len(d.Expires) > 0 {
+			expiry, err := time.Parse(time.RFC3339, d.Expires)
+			if err != nil {
+				return nil, fmt.Errorf("device %s has invalid key expiry %q: %w", d.Hostname, d.Expires, err)
+			}
+			n.KeyExpiry = expiry
+		}
+		if len(d.LastSeen) > 0 {
+			lastSeen, err := time.Parse(time.RFC3339, d.LastSeen)
+			if err != nil {
+				return nil, fmt.Errorf("device %s has invalid last seen time %q: %w", d.Hostname, d.LastSeen, err)
+			}
+			n.LastSeen = lastSeen
+		}
+		for _, a := range d.Addresses {
+			ip, err := netip.ParseAddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("device %s has invalid address %q: %w", d.Hostname, a, err)
+			}
+			n.IPs = append(n.IPs, ip)
+		}
+		if s.Groups {
+			n.Groups = append(make([]string, 0), members[strings.ToLower(d.User)]...)
+		}
+		if s.Attrs {
+			attrs, err := s.deviceAttrs(ctx, d.NodeID)
+			if err != nil {
+				return nil, fmt.Errorf("device %s attributes: %w", d.Hostname, err)
+			}
+			n.Attrs = attrs
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// deviceAttrs returns the posture attributes of a device. Values are
+// formatted as strings so they can be compared with -attr.
+func (s *APISource) deviceAttrs(ctx context.Context, id string) (map[string]string, error) {
+	var resp struct {
+		Attributes map[string]any `json:"attributes"`
+	}
+	if err := s.get(ctx, "/api/v2/device/"+url.PathEscape(id)+"/attributes", &resp); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string]string, len(resp.Attributes))
+	for k, v := range resp.Attributes {
+		attrs[k] = fmt.Sprint(v)
+	}
+	return attrs, nil
+}
+
+// groupMembers returns the groups of every user in the tailnet policy file,
+// keyed by lower case login name.
+func (s *APISource) groupMembers(ctx context.Context) (map[string][]string, error) {
+	var policy struct {
+		Groups map[string][]string `json:"groups"`
+	}
+	if err := s.get(ctx, "/api/v2/tailnet/"+url.PathEscape(s.Tailnet)+"/acl", &policy); err != nil {
+		return nil, fmt.Errorf("policy file groups: %w", err)
+	}
+	members := make(map[string][]string)
+	for group, users := range policy.Groups {
+		for _, u := range users {
+			members[strings.ToLower(u)] = append(members[strings.ToLower(u)], group)
+		}
+	}
+	return members, nil
+}
+
+// get fetches path and decodes the json response into v. With oauth, an
+// expired token is refreshed and the request retried once.
+func (s *APISource) get(ctx context.Context, path string, v any) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.BaseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		if err := s.authorize(ctx, req); err != nil {
+			return err
+		}
+		// The policy file is hujson unless json is asked for.
+		req.Header.Set("Accept", "application/json")
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && len(s.ClientID) > 0 && attempt == 0 {
+			s.resetToken()
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("tailscale api %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+		}
+		return json.Unmarshal(body, v)
+	}
+}
+
+func (s *APISource) authorize(ctx context.Context, req *http.Request) error {
+	if len(s.ClientID) == 0 {
+		req.SetBasicAuth(s.APIKey, "")
+		return nil
+	}
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// accessToken returns a cached oauth access token, fetching a new one when
+// it is missing or about to expire.
+func (s *APISource) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.token) > 0 && time.Until(s.expires) > time.Minute {
+		return s.token, nil
+	}
+
+	form := url.Values{
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+"/api/v2/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("tailscale oauth token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New("tailscale oauth token: empty access token")
+	}
+	s.token = token.AccessToken
+	s.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+func (s *APISource) resetToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
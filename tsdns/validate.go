@@ -0,0 +1,79 @@
+package tsdns
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Cloudflare limits on record names, in bytes.
+const (
+	maxNameLength  = 253
+	maxLabelLength = 63
+)
+
+// Tailscale assigns addresses from the cgnat range and its own ula prefix.
+var (
+	cgnat      = netip.MustParsePrefix("100.64.0.0/10")
+	tailnetULA = netip.MustParsePrefix("fd7a:115c:a1e0::/48")
+)
+
+// tailnetIP reports whether ip is in one of tailscale's address ranges.
+func tailnetIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return cgnat.Contains(ip) || tailnetULA.Contains(ip)
+}
+
+// validateRecord returns the ways r breaks cloudflare's record constraints,
+// so a dry run can report them before a real run is rejected mid batch.
+func validateRecord(r cloudflare.DNSRecord) []string {
+	problems := validateName(r.Name)
+
+	proxied := r.Proxied != nil && *r.Proxied
+	switch r.Type {
+	case "A", "AAAA":
+		ip, err := netip.ParseAddr(r.Content)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("content %q is not an ip", r.Content))
+			break
+		}
+		if (r.Type == "A") != ip.Is4() {
+			problems = append(problems, fmt.Sprintf("content %s does not fit an %s record", ip, r.Type))
+		}
+		if proxied && (cgnat.Contains(ip) || ip.IsPrivate() || !ip.IsGlobalUnicast()) {
+			problems = append(problems, fmt.Sprintf("cloudflare cannot proxy to the non public address %s", ip))
+		}
+	case "CNAME":
+		if !validHostname(r.Content) {
+			problems = append(problems, fmt.Sprintf("cname target %q is not a valid hostname", r.Content))
+		}
+	case "TXT":
+		if proxied {
+			problems = append(problems, "cloudflare cannot proxy TXT records")
+		}
+	}
+	return problems
+}
+
+// validateName returns the ways name breaks cloudflare's name constraints.
+func validateName(name string) []string {
+	var problems []string
+	if len(name) > maxNameLength {
+		problems = append(problems, fmt.Sprintf("name is %d characters, the limit is %d", len(name), maxNameLength))
+	}
+	for _, label := range strings.Split(name, ".") {
+		switch {
+		case len(label) == 0:
+			problems = append(problems, "name has an empty label")
+		case len(label) > maxLabelLength:
+			problems = append(problems, fmt.Sprintf("label %q is %d characters, the limit is %d", label, len(label), maxLabelLength))
+		case strings.Trim(label, "abcdefghijklmnopqrstuvwxyz0123456789-_*") != "":
+			problems = append(problems, fmt.Sprintf("label %q has characters other than letters, digits, '-' and '_'", label))
+		case strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-"):
+			problems = append(problems, fmt.Sprintf("label %q starts or ends with '-'", label))
+		}
+	}
+	return problems
+}